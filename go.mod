@@ -4,6 +4,7 @@ go 1.25.7
 
 require (
 	github.com/jonwraymond/toolexec v0.2.1
+	github.com/klauspost/compress v1.18.0
 	k8s.io/api v0.35.0
 	k8s.io/apimachinery v0.35.0
 	k8s.io/client-go v0.35.0
@@ -0,0 +1,90 @@
+// Package compression implements the gzip and zstd content codings used by
+// remotehttp clients and servers.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings, as used in Content-Encoding and Accept-Encoding.
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Supported lists the codings in order of preference.
+var Supported = []string{Zstd, Gzip}
+
+// AcceptEncoding is the Accept-Encoding value for Supported.
+var AcceptEncoding = strings.Join(Supported, ", ")
+
+// ErrUnsupported is returned for unknown codings.
+var ErrUnsupported = errors.New("unsupported content encoding")
+
+// Compress encodes data with coding.
+func Compress(coding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch coding {
+	case Gzip:
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case Zstd:
+		w, err := zstd.NewWriter(&buf, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			_ = w.Close()
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupported, coding)
+	}
+	return buf.Bytes(), nil
+}
+
+// NewReader decodes r according to coding, which is a Content-Encoding
+// header value. Empty and "identity" codings return r unchanged. Closing
+// the result closes r.
+func NewReader(coding string, r io.ReadCloser) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(coding)) {
+	case "", "identity":
+		return r, nil
+	case Gzip, "x-gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: zr, close: func() error { _ = zr.Close(); return r.Close() }}, nil
+	case Zstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: zr, close: func() error { zr.Close(); return r.Close() }}, nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupported, coding)
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error { return r.close() }
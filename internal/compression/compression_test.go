@@ -0,0 +1,52 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("print('hello')\n", 1000))
+	for _, coding := range Supported {
+		compressed, err := Compress(coding, data)
+		if err != nil {
+			t.Fatalf("%s: Compress error: %v", coding, err)
+		}
+		if len(compressed) >= len(data) {
+			t.Fatalf("%s: compressed %d bytes to %d", coding, len(data), len(compressed))
+		}
+		r, err := NewReader(strings.ToUpper(coding), io.NopCloser(bytes.NewReader(compressed)))
+		if err != nil {
+			t.Fatalf("%s: NewReader error: %v", coding, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: read error: %v", coding, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s: round trip mismatch", coding)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatalf("%s: Close error: %v", coding, err)
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	if _, err := Compress("br", nil); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	if _, err := NewReader("br", io.NopCloser(strings.NewReader(""))); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	r, err := NewReader("identity", io.NopCloser(strings.NewReader("x")))
+	if err != nil {
+		t.Fatalf("NewReader error: %v", err)
+	}
+	if data, _ := io.ReadAll(r); string(data) != "x" {
+		t.Fatalf("identity read %q", data)
+	}
+}
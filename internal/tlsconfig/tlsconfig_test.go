@@ -0,0 +1,205 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// newTLSServer serves with a certificate for name issued by ca. If clientCA
+// is set, clients must present a certificate it issued.
+func newTLSServer(t *testing.T, ca *testCert, name string, clientCA *testCert) *httptest.Server {
+	t.Helper()
+	leaf := newTestCert(t, name, ca, false)
+	pair, err := tls.X509KeyPair(leaf.certPEM, leaf.keyPEM)
+	if err != nil {
+		t.Fatalf("key pair: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA.cert)
+		srv.TLS.ClientCAs = pool
+		srv.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, srv *httptest.Server, cfg Config, skipVerify bool) error {
+	t.Helper()
+	rt, err := Transport(http.DefaultTransport.(*http.Transport).Clone(), cfg, skipVerify)
+	if err != nil {
+		t.Fatalf("Transport error: %v", err)
+	}
+	client := &http.Client{Transport: rt, Timeout: 5 * time.Second}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestTransportCABundle(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	other := newTestCert(t, "other-ca", nil, true)
+	srv := newTLSServer(t, ca, "runtime.internal", nil)
+
+	if err := get(t, srv, Config{CAPEM: ca.certPEM}, false); err != nil {
+		t.Fatalf("request with CA bundle: %v", err)
+	}
+	if err := get(t, srv, Config{CAPEM: other.certPEM}, false); err == nil {
+		t.Fatal("expected failure with an untrusted CA")
+	}
+	if err := get(t, srv, Config{CAPEM: ca.certPEM, ServerName: "runtime.internal"}, false); err != nil {
+		t.Fatalf("request with server name override: %v", err)
+	}
+	if err := get(t, srv, Config{CAPEM: ca.certPEM, ServerName: "elsewhere.internal"}, false); err == nil {
+		t.Fatal("expected failure with a mismatched server name")
+	}
+}
+
+func TestTransportClientCertificate(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	client := newTestCert(t, "client", ca, false)
+	srv := newTLSServer(t, ca, "runtime.internal", ca)
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "tls.crt"), client.certPEM)
+	writeFile(t, filepath.Join(dir, "tls.key"), client.keyPEM)
+
+	if err := get(t, srv, Config{CAPEM: ca.certPEM}, false); err == nil {
+		t.Fatal("expected failure without a client certificate")
+	}
+	if err := get(t, srv, Config{CAPEM: ca.certPEM, CertPEM: client.certPEM, KeyPEM: client.keyPEM}, false); err != nil {
+		t.Fatalf("request with inline client certificate: %v", err)
+	}
+	cfg := Config{CAPEM: ca.certPEM, CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")}
+	if err := get(t, srv, cfg, false); err != nil {
+		t.Fatalf("request with client certificate files: %v", err)
+	}
+}
+
+func TestTransportPinning(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	srv := newTLSServer(t, ca, "runtime.internal", nil)
+	sum := sha256.Sum256(srv.TLS.Certificates[0].Certificate[0])
+
+	// A pin alone accepts a certificate that does not chain to a trusted root.
+	if err := get(t, srv, Config{PinnedSHA256: []string{hex.EncodeToString(sum[:])}}, false); err != nil {
+		t.Fatalf("request with pin: %v", err)
+	}
+	wrong := sha256.Sum256([]byte("other"))
+	if err := get(t, srv, Config{PinnedSHA256: []string{hex.EncodeToString(wrong[:])}}, false); err == nil {
+		t.Fatal("expected failure with a mismatched pin")
+	}
+	if err := get(t, srv, Config{PinnedSHA256: []string{hex.EncodeToString(wrong[:])}}, true); err == nil {
+		t.Fatal("expected pins to be enforced with skip verify")
+	}
+	if _, err := Transport(&http.Transport{}, Config{PinnedSHA256: []string{"zz"}}, false); err == nil {
+		t.Fatal("expected invalid pin error")
+	}
+}
+
+func TestTransportReloadsRotatedCA(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	other := newTestCert(t, "other-ca", nil, true)
+	srv := newTLSServer(t, ca, "runtime.internal", nil)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, caFile, other.certPEM)
+	rt, err := Transport(http.DefaultTransport.(*http.Transport).Clone(), Config{CAFile: caFile, ReloadInterval: time.Millisecond}, false)
+	if err != nil {
+		t.Fatalf("Transport error: %v", err)
+	}
+	client := &http.Client{Transport: rt, Timeout: 5 * time.Second}
+	defer client.CloseIdleConnections()
+
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("expected failure before rotation")
+	}
+	writeFile(t, caFile, append(other.certPEM, ca.certPEM...))
+	// Make the change visible on filesystems with coarse timestamps.
+	future := time.Now().Add(time.Second)
+	_ = os.Chtimes(caFile, future, future)
+	time.Sleep(5 * time.Millisecond)
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request after rotation: %v", err)
+	}
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,198 @@
+package kubernetes
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// admission bounds the number of in-flight Jobs per client and per namespace.
+// A nil admission admits everything.
+type admission struct {
+	global       *semaphore
+	perNamespace int
+
+	mu         sync.Mutex
+	namespaces map[string]*semaphore
+}
+
+func newAdmission(maxJobs, maxPerNamespace int) *admission {
+	if maxJobs <= 0 && maxPerNamespace <= 0 {
+		return nil
+	}
+	a := &admission{
+		perNamespace: maxPerNamespace,
+		namespaces:   make(map[string]*semaphore),
+	}
+	if maxJobs > 0 {
+		a.global = newSemaphore(maxJobs)
+	}
+	return a
+}
+
+// acquire blocks until a slot is available for namespace or ctx ends.
+// The namespace slot is taken first so a saturated namespace does not hold
+// client-wide slots while it waits.
+func (a *admission) acquire(ctx context.Context, namespace string) (func(), error) {
+	if a == nil {
+		return func() {}, nil
+	}
+
+	var ns *semaphore
+	if a.perNamespace > 0 {
+		ns = a.namespace(namespace)
+		if err := ns.acquire(ctx); err != nil {
+			a.releaseNamespace(namespace, ns)
+			return nil, err
+		}
+	}
+	if a.global != nil {
+		if err := a.global.acquire(ctx); err != nil {
+			if ns != nil {
+				ns.release()
+				a.releaseNamespace(namespace, ns)
+			}
+			return nil, err
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if a.global != nil {
+				a.global.release()
+			}
+			if ns != nil {
+				ns.release()
+				a.releaseNamespace(namespace, ns)
+			}
+		})
+	}, nil
+}
+
+func (a *admission) namespace(name string) *semaphore {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sem, ok := a.namespaces[name]
+	if !ok {
+		sem = newSemaphore(a.perNamespace)
+		a.namespaces[name] = sem
+	}
+	sem.refs++
+	return sem
+}
+
+// releaseNamespace drops a reference and forgets idle namespaces.
+func (a *admission) releaseNamespace(name string, sem *semaphore) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sem.refs--
+	if sem.refs == 0 {
+		delete(a.namespaces, name)
+	}
+}
+
+// semaphore is a counting semaphore that grants slots in FIFO order.
+type semaphore struct {
+	mu      sync.Mutex
+	limit   int
+	inUse   int
+	waiters list.List // of chan struct{}
+
+	// refs is guarded by admission.mu.
+	refs int
+}
+
+func newSemaphore(limit int) *semaphore {
+	return &semaphore{limit: limit}
+}
+
+func (s *semaphore) acquire(ctx context.Context) error {
+	s.mu.Lock()
+	if s.inUse < s.limit && s.waiters.Len() == 0 {
+		s.inUse++
+		s.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	elem := s.waiters.PushBack(ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-ready:
+			// The slot was handed over concurrently with cancellation; pass it on.
+			s.mu.Unlock()
+			s.release()
+		default:
+			s.waiters.Remove(elem)
+			s.mu.Unlock()
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees a slot, handing it directly to the oldest waiter if any.
+func (s *semaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if front := s.waiters.Front(); front != nil {
+		s.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	s.inUse--
+}
+
+// createJob creates job, retrying exceeded-quota rejections when quota-aware
+// admission is enabled.
+func (c *Client) createJob(ctx context.Context, namespace string, job *batchv1.Job) (*batchv1.Job, error) {
+	for {
+		created, err := c.clientset.BatchV1().Jobs(namespace).Create(ctx, job, metav1.CreateOptions{})
+		if err == nil {
+			return created, nil
+		}
+		if c.quotaRetry <= 0 || !c.quotaExceeded(err) {
+			return nil, fmt.Errorf("%w: %w", ErrPodCreationFailed, err)
+		}
+		if c.logger != nil {
+			c.logger.Warn("kubernetes quota exceeded, waiting to retry", "job", job.Name, "namespace", namespace, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: waiting for quota: %w (last error: %v)", ErrPodCreationFailed, ctx.Err(), err)
+		case <-time.After(c.quotaRetry):
+		}
+	}
+}
+
+func (c *Client) quotaExceeded(err error) bool {
+	if c.quotaCheck != nil {
+		return c.quotaCheck(err)
+	}
+	return IsQuotaExceeded(err)
+}
+
+// IsQuotaExceeded reports whether err is a ResourceQuota admission
+// rejection: a 403 Forbidden API status whose message carries the quota
+// plugin's "exceeded quota" reason. Other Forbidden errors, such as RBAC
+// denials, are not quota rejections.
+func IsQuotaExceeded(err error) bool {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || !apierrors.IsForbidden(err) {
+		return false
+	}
+	return strings.Contains(strings.ToLower(status.Status().Message), "exceeded quota")
+}
@@ -0,0 +1,209 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSemaphoreFIFO(t *testing.T) {
+	sem := newSemaphore(1)
+	if err := sem.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			if err := sem.acquire(context.Background()); err != nil {
+				t.Errorf("acquire %d: %v", i, err)
+				return
+			}
+			order <- i
+		}(i)
+		waitForWaiters(t, sem, i+1)
+	}
+
+	for want := 0; want < 3; want++ {
+		sem.release()
+		if got := <-order; got != want {
+			t.Fatalf("granted waiter %d, want %d", got, want)
+		}
+	}
+}
+
+func waitForWaiters(t *testing.T, sem *semaphore, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		sem.mu.Lock()
+		got := sem.waiters.Len()
+		sem.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d waiters", n)
+}
+
+func TestSemaphoreCancelledWaiterLeavesQueue(t *testing.T) {
+	sem := newSemaphore(1)
+	if err := sem.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := sem.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire error = %v, want deadline exceeded", err)
+	}
+
+	sem.release()
+	if err := sem.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+}
+
+func TestAdmissionPerNamespace(t *testing.T) {
+	a := newAdmission(0, 1)
+	release, err := a.acquire(context.Background(), "team-a")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	// Another namespace is not blocked by team-a.
+	releaseB, err := a.acquire(context.Background(), "team-b")
+	if err != nil {
+		t.Fatalf("acquire team-b: %v", err)
+	}
+	releaseB()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := a.acquire(ctx, "team-a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire error = %v, want deadline exceeded", err)
+	}
+
+	release()
+	release() // idempotent
+	if len(a.namespaces) != 0 {
+		t.Fatalf("expected idle namespaces to be forgotten, got %d", len(a.namespaces))
+	}
+}
+
+func TestCreateJobRetriesExceededQuota(t *testing.T) {
+	clientset := fake.NewClientset()
+	attempts := 0
+	clientset.PrependReactor("create", "jobs", func(k8stesting.Action) (bool, runtime.Object, error) {
+		attempts++
+		if attempts < 3 {
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "batch", Resource: "jobs"}, "toolrun-1",
+				errors.New("exceeded quota: compute, requested: count/jobs.batch=1"))
+		}
+		return false, nil, nil
+	})
+
+	client, err := NewClient(ClientConfig{Clientset: clientset, QuotaRetryInterval: time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "toolrun-1", Namespace: "default"}}
+	if _, err := client.createJob(context.Background(), "default", job); err != nil {
+		t.Fatalf("createJob error: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestCreateJobQuotaWithoutRetryFails(t *testing.T) {
+	clientset := fake.NewClientset()
+	clientset.PrependReactor("create", "jobs", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "batch", Resource: "jobs"}, "toolrun-1",
+			errors.New("exceeded quota: compute"))
+	})
+
+	client, err := NewClient(ClientConfig{Clientset: clientset}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "toolrun-1", Namespace: "default"}}
+	if _, err := client.createJob(context.Background(), "default", job); !errors.Is(err, ErrPodCreationFailed) {
+		t.Fatalf("createJob error = %v, want ErrPodCreationFailed", err)
+	}
+}
+
+func TestCreateJobDoesNotRetryOtherForbidden(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+	}{
+		{name: "rbac", err: apierrors.NewForbidden(schema.GroupResource{Group: "batch", Resource: "jobs"}, "toolrun-1",
+			errors.New(`User "system:serviceaccount:default:toolexec" cannot create resource "jobs"`))},
+		{name: "plain error", err: errors.New("exceeded quota: compute")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clientset := fake.NewClientset()
+			attempts := 0
+			clientset.PrependReactor("create", "jobs", func(k8stesting.Action) (bool, runtime.Object, error) {
+				attempts++
+				return true, nil, tc.err
+			})
+
+			client, err := NewClient(ClientConfig{Clientset: clientset, QuotaRetryInterval: time.Millisecond}, nil)
+			if err != nil {
+				t.Fatalf("NewClient error: %v", err)
+			}
+
+			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "toolrun-1", Namespace: "default"}}
+			if _, err := client.createJob(context.Background(), "default", job); !errors.Is(err, ErrPodCreationFailed) {
+				t.Fatalf("createJob error = %v, want ErrPodCreationFailed", err)
+			}
+			if attempts != 1 {
+				t.Fatalf("attempts = %d, want 1", attempts)
+			}
+		})
+	}
+}
+
+func TestCreateJobCustomQuotaPredicate(t *testing.T) {
+	clientset := fake.NewClientset()
+	attempts := 0
+	clientset.PrependReactor("create", "jobs", func(k8stesting.Action) (bool, runtime.Object, error) {
+		attempts++
+		if attempts < 2 {
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "batch", Resource: "jobs"}, "toolrun-1",
+				errors.New("Kontingent überschritten"))
+		}
+		return false, nil, nil
+	})
+
+	client, err := NewClient(ClientConfig{
+		Clientset:          clientset,
+		QuotaRetryInterval: time.Millisecond,
+		QuotaExceeded:      apierrors.IsForbidden,
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "toolrun-1", Namespace: "default"}}
+	if _, err := client.createJob(context.Background(), "default", job); err != nil {
+		t.Fatalf("createJob error: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts = %d, want 2", attempts)
+	}
+}
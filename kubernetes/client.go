@@ -3,16 +3,17 @@ package kubernetes
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"strconv"
-	"strings"
 	"time"
 
 	corekube "github.com/jonwraymond/toolexec/runtime/backend/kubernetes"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -29,10 +30,24 @@ type PodRunner = corekube.PodRunner
 type HealthChecker = corekube.HealthChecker
 type ImageResolver = corekube.ImageResolver
 
+// IdempotencyKeyLabel is the PodSpec label carrying a caller-supplied
+// idempotency key. Runs with the same key in the same namespace share one
+// Job: a retried Run attaches to the existing Job (until JobTTL removes it)
+// instead of starting a duplicate.
+const IdempotencyKeyLabel = "toolruntime.idempotency-key"
+
+// maxJobNameAttempts bounds Job creation retries on name collisions.
+const maxJobNameAttempts = 3
+
+// idempotentAttachTimeout bounds how long a Run waits for a concurrent Run
+// with the same idempotency key to create its Job.
+const idempotentAttachTimeout = 30 * time.Second
+
 var (
 	ErrClientNotConfigured = corekube.ErrClientNotConfigured
 	ErrPodCreationFailed   = corekube.ErrPodCreationFailed
 	ErrPodExecutionFailed  = corekube.ErrPodExecutionFailed
+	ErrSecurityViolation   = corekube.ErrSecurityViolation
 )
 
 // ClientConfig configures a Kubernetes API client.
@@ -60,6 +75,57 @@ type ClientConfig struct {
 
 	// JobPrefix prefixes job names for executions.
 	JobPrefix string
+
+	// MaxConcurrentJobs caps in-flight Jobs for this client; zero means unlimited.
+	// Callers beyond the limit wait in FIFO order until a slot frees up or ctx ends.
+	MaxConcurrentJobs int
+
+	// MaxConcurrentJobsPerNamespace caps in-flight Jobs per namespace; zero means unlimited.
+	MaxConcurrentJobsPerNamespace int
+
+	// QuotaRetryInterval enables quota-aware admission. When Job creation is
+	// rejected with an exceeded-quota error, creation is retried at this
+	// interval until it succeeds or ctx ends. Zero fails immediately.
+	QuotaRetryInterval time.Duration
+
+	// QuotaExceeded reports whether a Job creation error is a quota
+	// rejection worth retrying. Override it for API servers whose quota
+	// admission reports rejections differently.
+	// Default: IsQuotaExceeded
+	QuotaExceeded func(error) bool
+
+	// MaxLogBytes caps how much pod log output is kept in memory. With
+	// LogRetainHead it also caps how much is fetched from the API server.
+	// Default: 16MiB
+	MaxLogBytes int64
+
+	// LogRetention selects which part of oversized logs is kept.
+	// Default: LogRetainHead
+	LogRetention LogRetention
+
+	// LogTailLines, when positive, only fetches the last N log lines.
+	LogTailLines int64
+
+	// ImageResolver optionally resolves and vets PodSpec.Image before the
+	// Job is created (see RegistryResolver).
+	ImageResolver ImageResolver
+
+	// RunIdentity, when set, gives every run its own ServiceAccount instead
+	// of PodSpec.ServiceAccount. See RunIdentityConfig.
+	RunIdentity *RunIdentityConfig
+
+	// TenantNamespaces, when set, runs labeled with TenantLabel in a
+	// provisioned per-tenant namespace. See TenantNamespaceConfig.
+	TenantNamespaces *TenantNamespaceConfig
+
+	// EnvReferences resolves secret://, configmap:// and secretfile://
+	// values in PodSpec.Env to Kubernetes object references (see
+	// SecretMountRoot).
+	// Default: false (values are passed literally)
+	EnvReferences bool
+
+	// Clientset overrides the clientset built from the kubeconfig settings.
+	Clientset kubernetes.Interface
 }
 
 // Client implements PodRunner and HealthChecker using client-go.
@@ -69,10 +135,71 @@ type Client struct {
 	jobTTL       time.Duration
 	jobPrefix    string
 	logger       Logger
+	admission    *admission
+	quotaRetry   time.Duration
+	quotaCheck   func(error) bool
+	resolver     ImageResolver
+	runIdentity  *RunIdentityConfig
+	redactor     *redactingLogger
+	tenants      *tenantNamespaces
+	maxLogBytes  int64
+	logRetention LogRetention
+	logTailLines int64
+	envRefs      bool
 }
 
 // NewClient creates a new Kubernetes client using the provided configuration.
 func NewClient(cfg ClientConfig, logger Logger) (*Client, error) {
+	clientset := cfg.Clientset
+	if clientset == nil {
+		built, err := buildClientset(cfg)
+		if err != nil {
+			return nil, err
+		}
+		clientset = built
+	}
+
+	poll := cfg.PollInterval
+	if poll == 0 {
+		poll = 2 * time.Second
+	}
+	jobTTL := cfg.JobTTL
+	if jobTTL == 0 {
+		jobTTL = 10 * time.Minute
+	}
+	jobPrefix := cfg.JobPrefix
+	if jobPrefix == "" {
+		jobPrefix = "toolrun"
+	}
+	maxLogBytes := cfg.MaxLogBytes
+	if maxLogBytes <= 0 {
+		maxLogBytes = 16 << 20
+	}
+
+	client := &Client{
+		clientset:    clientset,
+		pollInterval: poll,
+		jobTTL:       jobTTL,
+		jobPrefix:    jobPrefix,
+		admission:    newAdmission(cfg.MaxConcurrentJobs, cfg.MaxConcurrentJobsPerNamespace),
+		quotaRetry:   cfg.QuotaRetryInterval,
+		quotaCheck:   cfg.QuotaExceeded,
+		resolver:     cfg.ImageResolver,
+		runIdentity:  cfg.RunIdentity,
+		tenants:      newTenantNamespaces(cfg.TenantNamespaces),
+		maxLogBytes:  maxLogBytes,
+		logRetention: cfg.LogRetention,
+		logTailLines: cfg.LogTailLines,
+		envRefs:      cfg.EnvReferences,
+	}
+	if redactor := newRedactingLogger(logger); redactor != nil {
+		client.logger = redactor
+		client.redactor = redactor
+	}
+	return client, nil
+}
+
+func buildClientset(cfg ClientConfig) (kubernetes.Interface, error) {
 	var restCfg *rest.Config
 	var err error
 
@@ -101,31 +228,7 @@ func NewClient(cfg ClientConfig, logger Logger) (*Client, error) {
 		restCfg.Burst = cfg.Burst
 	}
 
-	clientset, err := kubernetes.NewForConfig(restCfg)
-	if err != nil {
-		return nil, err
-	}
-
-	poll := cfg.PollInterval
-	if poll == 0 {
-		poll = 2 * time.Second
-	}
-	jobTTL := cfg.JobTTL
-	if jobTTL == 0 {
-		jobTTL = 10 * time.Minute
-	}
-	jobPrefix := cfg.JobPrefix
-	if jobPrefix == "" {
-		jobPrefix = "toolrun"
-	}
-
-	return &Client{
-		clientset:    clientset,
-		pollInterval: poll,
-		jobTTL:       jobTTL,
-		jobPrefix:    jobPrefix,
-		logger:       logger,
-	}, nil
+	return kubernetes.NewForConfig(restCfg)
 }
 
 // Ping verifies the Kubernetes API is reachable.
@@ -139,17 +242,196 @@ func (c *Client) Ping(ctx context.Context) error {
 
 // Run executes the given pod spec as a Kubernetes Job.
 func (c *Client) Run(ctx context.Context, spec PodSpec) (PodResult, error) {
+	result, err := c.RunDetailed(ctx, spec)
+	return result.PodResult, err
+}
+
+// RunDetailed is Run, additionally reporting whether the pod logs were
+// truncated to MaxLogBytes and how large they were.
+func (c *Client) RunDetailed(ctx context.Context, spec PodSpec) (RunResult, error) {
 	if c.clientset == nil {
-		return PodResult{}, ErrClientNotConfigured
+		return RunResult{}, ErrClientNotConfigured
 	}
 	if err := spec.Validate(); err != nil {
-		return PodResult{}, err
+		return RunResult{}, err
 	}
 
-	runID, err := randomID()
+	image := spec.Image
+	if c.resolver != nil {
+		resolved, err := c.resolver.Resolve(ctx, image)
+		if err != nil {
+			return RunResult{}, fmt.Errorf("%w: resolve image: %w", ErrPodCreationFailed, err)
+		}
+		image = resolved
+	}
+
+	env, err := toPodEnv(spec.Env, c.envRefs)
+	if err != nil {
+		return RunResult{}, err
+	}
+	defer c.redactor.track(sensitiveEnvValues(spec.Env, c.envRefs))()
+
+	namespace, releaseNamespace, err := c.acquireNamespace(ctx, spec)
 	if err != nil {
-		return PodResult{}, err
+		return RunResult{}, err
 	}
+	defer releaseNamespace()
+
+	release, err := c.admission.acquire(ctx, namespace)
+	if err != nil {
+		return RunResult{}, err
+	}
+	defer release()
+
+	start := time.Now()
+
+	created, attached, err := c.launchJob(ctx, spec, namespace, image, env)
+	if err != nil {
+		c.forgetNamespace(namespace, err)
+		return RunResult{}, err
+	}
+
+	idempotent := created.Labels[IdempotencyKeyLabel] != ""
+	if c.logger != nil {
+		if attached {
+			c.logger.Info("kubernetes job attached", "job", created.Name, "namespace", namespace)
+		} else {
+			c.logger.Info("kubernetes job created", "job", created.Name, "namespace", namespace)
+		}
+	}
+
+	// Idempotent Jobs are left for TTLSecondsAfterFinished so a retried Run
+	// can still attach to them; their identity is garbage-collected with them.
+	if !idempotent {
+		if c.runIdentity != nil {
+			defer c.deleteRunIdentity(namespace, created.Name)
+		}
+		defer func() {
+			policy := metav1.DeletePropagationBackground
+			_ = c.clientset.BatchV1().Jobs(namespace).Delete(context.Background(), created.Name, metav1.DeleteOptions{
+				PropagationPolicy: &policy,
+			})
+		}()
+	}
+
+	if err := c.waitForCompletion(ctx, namespace, created.Name); err != nil {
+		return RunResult{}, err
+	}
+
+	pod, err := c.findPodForJob(ctx, namespace, created.Name)
+	if err != nil {
+		return RunResult{}, err
+	}
+
+	logs, err := c.readLogs(ctx, namespace, pod.Name, "runner")
+	if err != nil {
+		return RunResult{}, err
+	}
+	if logs.truncated() && c.logger != nil {
+		c.logger.Warn("kubernetes job logs truncated", "job", created.Name, "namespace", namespace, "bytes", logs.total, "limit", c.maxLogBytes)
+	}
+
+	exitCode := int32(0)
+	if len(pod.Status.ContainerStatuses) > 0 && pod.Status.ContainerStatuses[0].State.Terminated != nil {
+		exitCode = pod.Status.ContainerStatuses[0].State.Terminated.ExitCode
+	}
+
+	return RunResult{
+		PodResult: PodResult{
+			ExitCode: int(exitCode),
+			Stdout:   logs.String(),
+			Stderr:   "",
+			Duration: time.Since(start),
+		},
+		LogsTruncated: logs.truncated(),
+		LogBytes:      logs.total,
+	}, nil
+}
+
+// launchJob creates the Job for spec, retrying with a fresh name on
+// collisions. Runs carrying IdempotencyKeyLabel get a deterministic name and
+// attach to an existing Job with the same key instead of starting another.
+func (c *Client) launchJob(ctx context.Context, spec PodSpec, namespace, image string, env podEnv) (*batchv1.Job, bool, error) {
+	if key := spec.Labels[IdempotencyKeyLabel]; key != "" {
+		runID := idempotentRunID(key)
+		job := c.buildJob(spec, namespace, image, env, runID)
+		jobs := c.clientset.BatchV1().Jobs(namespace)
+
+		deadline := time.Now().Add(idempotentAttachTimeout)
+		for {
+			existing, err := jobs.Get(ctx, job.Name, metav1.GetOptions{})
+			switch {
+			case err == nil:
+				return attachJob(existing, runID)
+			case !apierrors.IsNotFound(err):
+				return nil, false, fmt.Errorf("%w: %v", ErrPodCreationFailed, err)
+			}
+
+			created, err := c.createRunJob(ctx, job)
+			if !apierrors.IsAlreadyExists(err) || time.Now().After(deadline) {
+				return created, false, err
+			}
+			// A concurrent Run with the same key has created its identity
+			// or Job; wait for the Job to appear and attach to it.
+			select {
+			case <-ctx.Done():
+				return nil, false, fmt.Errorf("%w: waiting for job %s: %w", ErrPodCreationFailed, job.Name, ctx.Err())
+			case <-time.After(c.pollInterval):
+			}
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		runID, err := randomID()
+		if err != nil {
+			return nil, false, err
+		}
+		created, err := c.createRunJob(ctx, c.buildJob(spec, namespace, image, env, runID))
+		if err == nil {
+			return created, false, nil
+		}
+		if !apierrors.IsAlreadyExists(err) || attempt == maxJobNameAttempts {
+			return nil, false, err
+		}
+		if c.logger != nil {
+			c.logger.Warn("kubernetes job name collision, retrying", "run", runID, "namespace", namespace, "attempt", attempt)
+		}
+	}
+}
+
+// createRunJob creates job together with its run identity, if configured.
+func (c *Client) createRunJob(ctx context.Context, job *batchv1.Job) (*batchv1.Job, error) {
+	if c.runIdentity != nil {
+		if err := c.createRunIdentity(ctx, job.Namespace, job.Name, job.Labels); err != nil {
+			return nil, err
+		}
+	}
+
+	created, err := c.createJob(ctx, job.Namespace, job)
+	if err != nil {
+		if c.runIdentity != nil {
+			c.deleteRunIdentity(job.Namespace, job.Name)
+		}
+		return nil, err
+	}
+
+	if c.runIdentity != nil {
+		if err := c.adoptRunIdentity(ctx, created); err != nil && c.logger != nil {
+			c.logger.Warn("kubernetes run identity adoption failed", "job", created.Name, "namespace", created.Namespace, "error", err)
+		}
+	}
+	return created, nil
+}
+
+func attachJob(job *batchv1.Job, runID string) (*batchv1.Job, bool, error) {
+	if job.Labels[IdempotencyKeyLabel] != runID {
+		return nil, false, fmt.Errorf("%w: job %s exists without matching idempotency key", ErrPodCreationFailed, job.Name)
+	}
+	return job, true, nil
+}
+
+// buildJob renders the Job object for a run.
+func (c *Client) buildJob(spec PodSpec, namespace, image string, env podEnv, runID string) *batchv1.Job {
 	jobName := fmt.Sprintf("%s-%s", c.jobPrefix, runID)
 
 	labels := map[string]string{
@@ -158,15 +440,20 @@ func (c *Client) Run(ctx context.Context, spec PodSpec) (PodResult, error) {
 	for k, v := range spec.Labels {
 		labels[k] = v
 	}
+	if labels[IdempotencyKeyLabel] != "" {
+		// The raw key may not be a valid label value; store its hash.
+		labels[IdempotencyKeyLabel] = runID
+	}
 
 	container := corev1.Container{
-		Name:       "runner",
-		Image:      spec.Image,
-		Command:    spec.Command,
-		Args:       spec.Args,
-		WorkingDir: spec.WorkingDir,
-		Env:        toEnvVars(spec.Env),
-		Resources:  toResourceRequirements(spec.Resources),
+		Name:         "runner",
+		Image:        image,
+		Command:      spec.Command,
+		Args:         spec.Args,
+		WorkingDir:   spec.WorkingDir,
+		Env:          env.vars,
+		VolumeMounts: env.mounts,
+		Resources:    toResourceRequirements(spec.Resources),
 		SecurityContext: &corev1.SecurityContext{
 			ReadOnlyRootFilesystem:   boolPtr(spec.Security.ReadOnlyRootfs),
 			AllowPrivilegeEscalation: boolPtr(false),
@@ -184,8 +471,13 @@ func (c *Client) Run(ctx context.Context, spec PodSpec) (PodResult, error) {
 	podSpec := corev1.PodSpec{
 		RestartPolicy:      corev1.RestartPolicyNever,
 		Containers:         []corev1.Container{container},
+		Volumes:            env.volumes,
 		ServiceAccountName: spec.ServiceAccount,
 	}
+	if c.runIdentity != nil {
+		podSpec.ServiceAccountName = jobName
+		podSpec.AutomountServiceAccountToken = boolPtr(len(c.runIdentity.Rules) > 0)
+	}
 	if spec.RuntimeClassName != "" {
 		podSpec.RuntimeClassName = &spec.RuntimeClassName
 	}
@@ -194,15 +486,21 @@ func (c *Client) Run(ctx context.Context, spec PodSpec) (PodResult, error) {
 		podSpec.HostNetwork = true
 	}
 
+	if c.tenants != nil {
+		podSpec.SecurityContext = &corev1.PodSecurityContext{
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		}
+	}
+
 	if spec.Timeout > 0 {
 		seconds := int64(spec.Timeout.Seconds())
 		podSpec.ActiveDeadlineSeconds = &seconds
 	}
 
-	job := &batchv1.Job{
+	return &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      jobName,
-			Namespace: spec.Namespace,
+			Namespace: namespace,
 			Labels:    labels,
 		},
 		Spec: batchv1.JobSpec{
@@ -216,50 +514,6 @@ func (c *Client) Run(ctx context.Context, spec PodSpec) (PodResult, error) {
 			},
 		},
 	}
-
-	start := time.Now()
-
-	created, err := c.clientset.BatchV1().Jobs(spec.Namespace).Create(ctx, job, metav1.CreateOptions{})
-	if err != nil {
-		return PodResult{}, fmt.Errorf("%w: %v", ErrPodCreationFailed, err)
-	}
-
-	if c.logger != nil {
-		c.logger.Info("kubernetes job created", "job", created.Name, "namespace", spec.Namespace)
-	}
-
-	defer func() {
-		policy := metav1.DeletePropagationBackground
-		_ = c.clientset.BatchV1().Jobs(spec.Namespace).Delete(context.Background(), created.Name, metav1.DeleteOptions{
-			PropagationPolicy: &policy,
-		})
-	}()
-
-	if err := c.waitForCompletion(ctx, spec.Namespace, created.Name); err != nil {
-		return PodResult{}, err
-	}
-
-	pod, err := c.findPodForJob(ctx, spec.Namespace, created.Name)
-	if err != nil {
-		return PodResult{}, err
-	}
-
-	stdout, err := c.readLogs(ctx, spec.Namespace, pod.Name, "runner")
-	if err != nil {
-		return PodResult{}, err
-	}
-
-	exitCode := int32(0)
-	if len(pod.Status.ContainerStatuses) > 0 && pod.Status.ContainerStatuses[0].State.Terminated != nil {
-		exitCode = pod.Status.ContainerStatuses[0].State.Terminated.ExitCode
-	}
-
-	return PodResult{
-		ExitCode: int(exitCode),
-		Stdout:   stdout,
-		Stderr:   "",
-		Duration: time.Since(start),
-	}, nil
 }
 
 func (c *Client) waitForCompletion(ctx context.Context, namespace, jobName string) error {
@@ -295,40 +549,32 @@ func (c *Client) findPodForJob(ctx context.Context, namespace, jobName string) (
 	return &pods.Items[0], nil
 }
 
-func (c *Client) readLogs(ctx context.Context, namespace, podName, container string) (string, error) {
-	req := c.clientset.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
+func (c *Client) readLogs(ctx context.Context, namespace, podName, container string) (*logCapture, error) {
+	opts := &corev1.PodLogOptions{
 		Container: container,
-	})
-	stream, err := req.Stream(ctx)
-	if err != nil {
-		return "", fmt.Errorf("%w: logs: %v", ErrPodExecutionFailed, err)
 	}
-	defer func() {
-		// Best-effort cleanup. The stream is already fully read below; close errors are not actionable here.
-		if err := stream.Close(); err != nil {
-			_ = err // explicitly ignore close errors
-		}
-	}()
-
-	data, err := io.ReadAll(stream)
+	if c.logTailLines > 0 {
+		opts.TailLines = &c.logTailLines
+	}
+	if c.logRetention != LogRetainTail && c.logRetention != LogRetainHeadTail {
+		// One byte past the limit is enough to detect truncation; the rest
+		// of a runaway log never leaves the node.
+		limit := c.maxLogBytes + 1
+		opts.LimitBytes = &limit
+	}
+	req := c.clientset.CoreV1().Pods(namespace).GetLogs(podName, opts)
+	stream, err := req.Stream(ctx)
 	if err != nil {
-		return "", fmt.Errorf("%w: logs read: %v", ErrPodExecutionFailed, err)
+		return nil, fmt.Errorf("%w: logs: %v", ErrPodExecutionFailed, err)
 	}
-	return string(data), nil
-}
+	defer func() { _ = stream.Close() }()
 
-func toEnvVars(env []string) []corev1.EnvVar {
-	out := make([]corev1.EnvVar, 0, len(env))
-	for _, item := range env {
-		if item == "" {
-			continue
-		}
-		parts := strings.SplitN(item, "=", 2)
-		if len(parts) == 2 {
-			out = append(out, corev1.EnvVar{Name: parts[0], Value: parts[1]})
-		}
+	// Tail retention needs the end of the log, so the stream is drained.
+	logs := newLogCapture(c.maxLogBytes, c.logRetention)
+	if _, err := io.Copy(logs, stream); err != nil {
+		return nil, fmt.Errorf("%w: logs read: %v", ErrPodExecutionFailed, err)
 	}
-	return out
+	return logs, nil
 }
 
 func toResourceRequirements(res ResourceSpec) corev1.ResourceRequirements {
@@ -357,13 +603,19 @@ func parseUserID(raw string) (int64, bool) {
 }
 
 func randomID() (string, error) {
-	var buf [4]byte
+	var buf [8]byte
 	if _, err := rand.Read(buf[:]); err != nil {
 		return "", err
 	}
 	return hex.EncodeToString(buf[:]), nil
 }
 
+// idempotentRunID derives a stable run ID from an idempotency key.
+func idempotentRunID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
 func boolPtr(v bool) *bool {
 	return &v
 }
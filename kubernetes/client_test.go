@@ -0,0 +1,205 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newRunClientset returns a fake clientset on which every Job completes
+// immediately with a single succeeded pod.
+func newRunClientset(t *testing.T) *fake.Clientset {
+	t.Helper()
+	clientset := fake.NewClientset()
+	clientset.PrependReactor("get", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.GetAction).GetName()
+		obj, err := clientset.Tracker().Get(batchv1.SchemeGroupVersion.WithResource("jobs"), action.GetNamespace(), name)
+		if err != nil {
+			return true, nil, err
+		}
+		job := obj.(*batchv1.Job).DeepCopy()
+		job.Status.Succeeded = 1
+		return true, job, nil
+	})
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		selector := action.(k8stesting.ListAction).GetListRestrictions().Labels
+		podLabels, err := labels.ConvertSelectorToLabelsMap(selector.String())
+		if err != nil {
+			return true, nil, err
+		}
+		return true, &corev1.PodList{Items: []corev1.Pod{{
+			ObjectMeta: metav1.ObjectMeta{Name: "runner-pod", Namespace: action.GetNamespace(), Labels: podLabels},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+			}}},
+		}}}, nil
+	})
+	return clientset
+}
+
+func TestClientRun(t *testing.T) {
+	clientset := newRunClientset(t)
+	var created *batchv1.Job
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).DeepCopy()
+		return false, nil, nil
+	})
+
+	client, err := NewClient(ClientConfig{Clientset: clientset, PollInterval: time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	result, err := client.Run(context.Background(), PodSpec{
+		Namespace: "default",
+		Image:     "toolruntime-sandbox:latest",
+		Env:       []string{"MODE=test"},
+	})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if result.Stdout != "fake logs" {
+		t.Fatalf("stdout = %q", result.Stdout)
+	}
+	if created == nil {
+		t.Fatal("expected job to be created")
+	}
+	env := created.Spec.Template.Spec.Containers[0].Env
+	if len(env) != 1 || env[0].Name != "MODE" || env[0].Value != "test" {
+		t.Fatalf("env = %#v", env)
+	}
+}
+
+func TestClientRunRetriesNameCollision(t *testing.T) {
+	clientset := newRunClientset(t)
+	attempts := 0
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		attempts++
+		if attempts == 1 {
+			name := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).Name
+			return true, nil, apierrors.NewAlreadyExists(batchv1.Resource("jobs"), name)
+		}
+		return false, nil, nil
+	})
+
+	client, err := NewClient(ClientConfig{Clientset: clientset, PollInterval: time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	if _, err := client.Run(context.Background(), PodSpec{Namespace: "default", Image: "toolruntime-sandbox:latest"}); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("create attempts = %d, want 2", attempts)
+	}
+}
+
+func TestClientRunIdempotencyKeyAttaches(t *testing.T) {
+	clientset := newRunClientset(t)
+	client, err := NewClient(ClientConfig{Clientset: clientset, PollInterval: time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	spec := PodSpec{
+		Namespace: "default",
+		Image:     "toolruntime-sandbox:latest",
+		Labels:    map[string]string{IdempotencyKeyLabel: "order 1234/charge"},
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.Run(context.Background(), spec); err != nil {
+			t.Fatalf("Run %d error: %v", i, err)
+		}
+	}
+
+	creates := 0
+	for _, action := range clientset.Actions() {
+		if action.Matches("create", "jobs") {
+			creates++
+		}
+	}
+	if creates != 1 {
+		t.Fatalf("jobs created = %d, want 1", creates)
+	}
+
+	jobs, err := clientset.BatchV1().Jobs("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list jobs: %v", err)
+	}
+	if len(jobs.Items) != 1 {
+		t.Fatalf("jobs = %d, want idempotent job retained for TTL", len(jobs.Items))
+	}
+	if got := jobs.Items[0].Labels[IdempotencyKeyLabel]; got != idempotentRunID("order 1234/charge") {
+		t.Fatalf("idempotency label = %q", got)
+	}
+}
+
+func TestClientRunIdempotencyKeyWaitsForConcurrentRun(t *testing.T) {
+	clientset := newRunClientset(t)
+	var once sync.Once
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		// A concurrent Run with the same key has created its identity and
+		// creates its Job shortly after.
+		sa := action.(k8stesting.CreateAction).GetObject().(*corev1.ServiceAccount)
+		once.Do(func() {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: sa.Name, Namespace: sa.Namespace, Labels: sa.Labels}}
+				_ = clientset.Tracker().Add(job)
+			}()
+		})
+		return true, nil, apierrors.NewAlreadyExists(corev1.Resource("serviceaccounts"), sa.Name)
+	})
+	client, err := NewClient(ClientConfig{Clientset: clientset, PollInterval: time.Millisecond, RunIdentity: &RunIdentityConfig{}}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	_, err = client.Run(context.Background(), PodSpec{
+		Namespace: "default",
+		Image:     "toolruntime-sandbox:latest",
+		Labels:    map[string]string{IdempotencyKeyLabel: "key"},
+	})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	for _, action := range clientset.Actions() {
+		if action.Matches("create", "jobs") {
+			t.Fatal("Run created a second job instead of attaching")
+		}
+	}
+}
+
+func TestClientRunIdempotencyKeyRejectsForeignJob(t *testing.T) {
+	clientset := newRunClientset(t)
+	client, err := NewClient(ClientConfig{Clientset: clientset, PollInterval: time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	runID := idempotentRunID("key")
+	foreign := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "toolrun-" + runID, Namespace: "default"}}
+	if err := clientset.Tracker().Add(foreign); err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+
+	_, err = client.Run(context.Background(), PodSpec{
+		Namespace: "default",
+		Image:     "toolruntime-sandbox:latest",
+		Labels:    map[string]string{IdempotencyKeyLabel: "key"},
+	})
+	if !errors.Is(err, ErrPodCreationFailed) {
+		t.Fatalf("Run error = %v, want ErrPodCreationFailed", err)
+	}
+}
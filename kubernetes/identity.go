@@ -0,0 +1,137 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RunIdentityConfig enables a dedicated ServiceAccount per run.
+//
+// The ServiceAccount (and Role/RoleBinding when Rules are set) share the
+// Job's name and are owned by the Job, so they are garbage-collected with it
+// even if the client exits before cleaning up.
+type RunIdentityConfig struct {
+	// Rules are granted to the run's ServiceAccount through a namespaced
+	// Role. Empty creates an identity with no API access and no mounted token.
+	Rules []rbacv1.PolicyRule
+}
+
+// createRunIdentity creates the ServiceAccount, Role and RoleBinding for a run.
+// On failure, anything this call created is removed.
+func (c *Client) createRunIdentity(ctx context.Context, namespace, name string, labels map[string]string) error {
+	meta := metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}
+
+	sa := &corev1.ServiceAccount{
+		ObjectMeta:                   meta,
+		AutomountServiceAccountToken: boolPtr(len(c.runIdentity.Rules) > 0),
+	}
+	if _, err := c.clientset.CoreV1().ServiceAccounts(namespace).Create(ctx, sa, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("%w: service account: %w", ErrPodCreationFailed, err)
+	}
+	if len(c.runIdentity.Rules) == 0 {
+		return nil
+	}
+
+	role := &rbacv1.Role{
+		ObjectMeta: meta,
+		Rules:      c.runIdentity.Rules,
+	}
+	if _, err := c.clientset.RbacV1().Roles(namespace).Create(ctx, role, metav1.CreateOptions{}); err != nil {
+		c.deleteIdentityObjects(namespace, name, identityServiceAccount)
+		return fmt.Errorf("%w: role: %w", ErrPodCreationFailed, err)
+	}
+
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: meta,
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      name,
+			Namespace: namespace,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     name,
+		},
+	}
+	if _, err := c.clientset.RbacV1().RoleBindings(namespace).Create(ctx, binding, metav1.CreateOptions{}); err != nil {
+		c.deleteIdentityObjects(namespace, name, identityRole, identityServiceAccount)
+		return fmt.Errorf("%w: role binding: %w", ErrPodCreationFailed, err)
+	}
+	return nil
+}
+
+// adoptRunIdentity sets the Job as owner of the run's identity objects.
+func (c *Client) adoptRunIdentity(ctx context.Context, job *batchv1.Job) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"ownerReferences": []metav1.OwnerReference{{
+				APIVersion:         batchv1.SchemeGroupVersion.String(),
+				Kind:               "Job",
+				Name:               job.Name,
+				UID:                job.UID,
+				BlockOwnerDeletion: boolPtr(true),
+			}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	namespace := job.Namespace
+	if _, err := c.clientset.CoreV1().ServiceAccounts(namespace).Patch(ctx, job.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	if len(c.runIdentity.Rules) == 0 {
+		return nil
+	}
+	if _, err := c.clientset.RbacV1().Roles(namespace).Patch(ctx, job.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	_, err = c.clientset.RbacV1().RoleBindings(namespace).Patch(ctx, job.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+type identityObject int
+
+const (
+	identityServiceAccount identityObject = iota
+	identityRole
+	identityRoleBinding
+)
+
+// deleteRunIdentity removes the run's identity objects. Best-effort: owner
+// references on the Job cover anything left behind.
+func (c *Client) deleteRunIdentity(namespace, name string) {
+	objects := []identityObject{identityServiceAccount}
+	if len(c.runIdentity.Rules) > 0 {
+		objects = append(objects, identityRoleBinding, identityRole)
+	}
+	c.deleteIdentityObjects(namespace, name, objects...)
+}
+
+func (c *Client) deleteIdentityObjects(namespace, name string, objects ...identityObject) {
+	ctx := context.Background()
+	for _, object := range objects {
+		var err error
+		switch object {
+		case identityServiceAccount:
+			err = c.clientset.CoreV1().ServiceAccounts(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		case identityRole:
+			err = c.clientset.RbacV1().Roles(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		case identityRoleBinding:
+			err = c.clientset.RbacV1().RoleBindings(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		}
+		if err != nil && !apierrors.IsNotFound(err) && c.logger != nil {
+			c.logger.Warn("kubernetes run identity cleanup failed", "name", name, "namespace", namespace, "error", err)
+		}
+	}
+}
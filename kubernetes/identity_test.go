@@ -0,0 +1,101 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestClientRunCreatesScopedIdentity(t *testing.T) {
+	clientset := newRunClientset(t)
+	var job *batchv1.Job
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		// Reactors run under the fake's lock, so read through the tracker.
+		if _, err := clientset.Tracker().Get(corev1.SchemeGroupVersion.WithResource("serviceaccounts"), "default", job.Name); err != nil {
+			t.Errorf("service account missing at job creation: %v", err)
+		}
+		if _, err := clientset.Tracker().Get(rbacv1.SchemeGroupVersion.WithResource("rolebindings"), "default", job.Name); err != nil {
+			t.Errorf("role binding missing at job creation: %v", err)
+		}
+		job.UID = "job-uid"
+		return false, nil, nil
+	})
+
+	var adopted int
+	clientset.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		adopted++
+		return false, nil, nil
+	})
+
+	client, err := NewClient(ClientConfig{
+		Clientset:    clientset,
+		PollInterval: time.Millisecond,
+		RunIdentity: &RunIdentityConfig{Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"get"},
+		}}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	if _, err := client.Run(context.Background(), PodSpec{
+		Namespace:      "default",
+		Image:          "toolruntime-sandbox:latest",
+		ServiceAccount: "shared",
+	}); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+
+	podSpec := job.Spec.Template.Spec
+	if podSpec.ServiceAccountName != job.Name {
+		t.Fatalf("service account = %q, want %q", podSpec.ServiceAccountName, job.Name)
+	}
+	if podSpec.AutomountServiceAccountToken == nil || !*podSpec.AutomountServiceAccountToken {
+		t.Fatal("expected token to be mounted for scoped identity")
+	}
+	if adopted != 3 {
+		t.Fatalf("adopted %d objects, want 3", adopted)
+	}
+
+	// The identity is removed together with the Job.
+	if _, err := clientset.CoreV1().ServiceAccounts("default").Get(context.Background(), job.Name, metav1.GetOptions{}); err == nil {
+		t.Fatal("expected service account to be deleted")
+	}
+}
+
+func TestClientRunIdentityWithoutRules(t *testing.T) {
+	clientset := newRunClientset(t)
+	client, err := NewClient(ClientConfig{
+		Clientset:    clientset,
+		PollInterval: time.Millisecond,
+		RunIdentity:  &RunIdentityConfig{},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	if err := client.createRunIdentity(context.Background(), "default", "toolrun-1", nil); err != nil {
+		t.Fatalf("createRunIdentity error: %v", err)
+	}
+	sa, err := clientset.CoreV1().ServiceAccounts("default").Get(context.Background(), "toolrun-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get service account: %v", err)
+	}
+	if sa.AutomountServiceAccountToken == nil || *sa.AutomountServiceAccountToken {
+		t.Fatal("expected token automount to be disabled")
+	}
+	roles, _ := clientset.RbacV1().Roles("default").List(context.Background(), metav1.ListOptions{})
+	if len(roles.Items) != 0 {
+		t.Fatalf("expected no roles, got %d", len(roles.Items))
+	}
+}
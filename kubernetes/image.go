@@ -0,0 +1,352 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrImageResolutionFailed is returned when an image tag cannot be resolved to a digest.
+var ErrImageResolutionFailed = errors.New("image resolution failed")
+
+const defaultRegistry = "docker.io"
+
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// RegistryResolverConfig configures a RegistryResolver.
+type RegistryResolverConfig struct {
+	// AllowedRepositories restricts which repositories may run. Entries are
+	// normalized repository names (e.g. "ghcr.io/acme/tool"); an entry ending
+	// in "/" matches every repository under that prefix. Empty allows all.
+	AllowedRepositories []string
+
+	// AllowedDigests pins repositories to known digests. When a repository
+	// has an entry, the resolved digest must be one of the listed values.
+	AllowedDigests map[string][]string
+
+	// PlainHTTPRegistries lists registry hosts contacted over plain HTTP
+	// (e.g. a local registry on "localhost:5000").
+	PlainHTTPRegistries []string
+
+	// Username and Password authenticate against the registry token service.
+	// Empty requests anonymous pull tokens.
+	Username string
+	Password string
+
+	// CacheTTL controls how long tag resolutions are cached.
+	// Default: 5m
+	CacheTTL time.Duration
+
+	// HTTPClient overrides the default HTTP client.
+	HTTPClient *http.Client
+
+	// Timeout sets request timeout if HTTPClient is not provided.
+	Timeout time.Duration
+}
+
+// RegistryResolver resolves image tags to digests against an OCI
+// distribution registry and enforces a repository/digest allowlist.
+type RegistryResolver struct {
+	allowedRepos   []string
+	allowedDigests map[string][]string
+	plainHTTP      []string
+	username       string
+	password       string
+	cacheTTL       time.Duration
+	httpClient     *http.Client
+
+	mu        sync.Mutex
+	cache     map[string]resolvedImage
+	lastSweep time.Time
+}
+
+type resolvedImage struct {
+	digest  string
+	expires time.Time
+}
+
+// NewRegistryResolver creates a resolver using the provided configuration.
+func NewRegistryResolver(cfg RegistryResolverConfig) *RegistryResolver {
+	cacheTTL := cfg.CacheTTL
+	if cacheTTL == 0 {
+		cacheTTL = 5 * time.Minute
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout == 0 {
+			timeout = 30 * time.Second
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	return &RegistryResolver{
+		allowedRepos:   cfg.AllowedRepositories,
+		allowedDigests: cfg.AllowedDigests,
+		plainHTTP:      cfg.PlainHTTPRegistries,
+		username:       cfg.Username,
+		password:       cfg.Password,
+		cacheTTL:       cacheTTL,
+		httpClient:     client,
+		cache:          make(map[string]resolvedImage),
+	}
+}
+
+// Resolve returns image pinned to a digest ("name@sha256:...").
+// Images that already carry a digest are checked against policy but not
+// looked up.
+func (r *RegistryResolver) Resolve(ctx context.Context, image string) (string, error) {
+	ref, err := parseImageRef(image)
+	if err != nil {
+		return "", err
+	}
+	if !r.repositoryAllowed(ref.repository()) {
+		return "", fmt.Errorf("%w: repository %s is not allowed", ErrSecurityViolation, ref.repository())
+	}
+
+	digest := ref.digest
+	if digest == "" {
+		digest, err = r.lookup(ctx, ref)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !r.digestAllowed(ref.repository(), digest) {
+		return "", fmt.Errorf("%w: digest %s is not allowed for %s", ErrSecurityViolation, digest, ref.repository())
+	}
+	return ref.name + "@" + digest, nil
+}
+
+func (r *RegistryResolver) repositoryAllowed(repo string) bool {
+	if len(r.allowedRepos) == 0 {
+		return true
+	}
+	for _, allowed := range r.allowedRepos {
+		if allowed == repo || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(repo, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RegistryResolver) digestAllowed(repo, digest string) bool {
+	digests, ok := r.allowedDigests[repo]
+	if !ok {
+		return true
+	}
+	return slices.Contains(digests, digest)
+}
+
+func (r *RegistryResolver) lookup(ctx context.Context, ref imageRef) (string, error) {
+	key := ref.repository() + ":" + ref.tag
+	r.mu.Lock()
+	if cached, ok := r.cache[key]; ok && time.Now().Before(cached.expires) {
+		r.mu.Unlock()
+		return cached.digest, nil
+	}
+	r.mu.Unlock()
+
+	digest, err := r.fetchDigest(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	now := time.Now()
+	r.evictExpired(now)
+	r.cache[key] = resolvedImage{digest: digest, expires: now.Add(r.cacheTTL)}
+	r.mu.Unlock()
+	return digest, nil
+}
+
+// evictExpired drops cached resolutions past their TTL, at most once per
+// CacheTTL. Callers hold r.mu.
+func (r *RegistryResolver) evictExpired(now time.Time) {
+	if now.Sub(r.lastSweep) < r.cacheTTL {
+		return
+	}
+	r.lastSweep = now
+	for key, cached := range r.cache {
+		if now.After(cached.expires) {
+			delete(r.cache, key)
+		}
+	}
+}
+
+func (r *RegistryResolver) fetchDigest(ctx context.Context, ref imageRef) (string, error) {
+	scheme := "https"
+	if slices.Contains(r.plainHTTP, ref.registry) {
+		scheme = "http"
+	}
+	host := ref.registry
+	if host == defaultRegistry {
+		host = "registry-1.docker.io"
+	}
+	manifestURL := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, host, ref.path, ref.tag)
+
+	resp, err := r.headManifest(ctx, manifestURL, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		_ = resp.Body.Close()
+		token, err := r.fetchToken(ctx, challenge, ref.path)
+		if err != nil {
+			return "", err
+		}
+		resp, err = r.headManifest(ctx, manifestURL, token)
+		if err != nil {
+			return "", err
+		}
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s: status %d", ErrImageResolutionFailed, ref.name, resp.StatusCode)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if !strings.Contains(digest, ":") {
+		return "", fmt.Errorf("%w: %s: registry returned no digest", ErrImageResolutionFailed, ref.name)
+	}
+	return digest, nil
+}
+
+func (r *RegistryResolver) headManifest(ctx context.Context, manifestURL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImageResolutionFailed, err)
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImageResolutionFailed, err)
+	}
+	return resp, nil
+}
+
+// fetchToken obtains a pull token from the realm named in a Bearer challenge.
+func (r *RegistryResolver) fetchToken(ctx context.Context, challenge, repoPath string) (string, error) {
+	params := parseBearerChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("%w: unsupported auth challenge %q", ErrImageResolutionFailed, challenge)
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("%w: token realm: %v", ErrImageResolutionFailed, err)
+	}
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", "repository:"+repoPath+":pull")
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrImageResolutionFailed, err)
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: token: %v", ErrImageResolutionFailed, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token: status %d", ErrImageResolutionFailed, resp.StatusCode)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: token decode: %v", ErrImageResolutionFailed, err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+func parseBearerChallenge(header string) map[string]string {
+	params := map[string]string{}
+	scheme, rest, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return params
+	}
+	for _, part := range strings.Split(rest, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		params[strings.ToLower(key)] = strings.Trim(value, `"`)
+	}
+	return params
+}
+
+// imageRef is a parsed container image reference.
+type imageRef struct {
+	name     string // reference as written, without tag or digest
+	registry string
+	path     string
+	tag      string
+	digest   string
+}
+
+func (r imageRef) repository() string {
+	return r.registry + "/" + r.path
+}
+
+func parseImageRef(image string) (imageRef, error) {
+	if image == "" || strings.ContainsAny(image, " \t\n") {
+		return imageRef{}, fmt.Errorf("%w: invalid image reference %q", ErrImageResolutionFailed, image)
+	}
+	var ref imageRef
+	name := image
+	if before, digest, ok := strings.Cut(name, "@"); ok {
+		name, ref.digest = before, digest
+	}
+	if slash := strings.LastIndex(name, "/"); strings.LastIndex(name, ":") > slash {
+		colon := strings.LastIndex(name, ":")
+		name, ref.tag = name[:colon], name[colon+1:]
+	}
+	if ref.tag == "" {
+		ref.tag = "latest"
+	}
+	ref.name = name
+
+	first, rest, hasSlash := strings.Cut(name, "/")
+	if hasSlash && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.registry, ref.path = first, rest
+	} else {
+		ref.registry, ref.path = defaultRegistry, name
+	}
+	if ref.registry == defaultRegistry && !strings.Contains(ref.path, "/") {
+		ref.path = "library/" + ref.path
+	}
+	if ref.path == "" {
+		return imageRef{}, fmt.Errorf("%w: invalid image reference %q", ErrImageResolutionFailed, image)
+	}
+	return ref, nil
+}
+
+var _ ImageResolver = (*RegistryResolver)(nil)
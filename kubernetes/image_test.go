@@ -0,0 +1,164 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDigest = "sha256:4b1f3e8c2a9d7f60e5b3c1a2d4f6e8b0c9a7d5e3f1b2c4d6e8f0a1b3c5d7e9f1"
+
+func newTestRegistry(t *testing.T, calls *int) (*httptest.Server, string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.URL.Path != "/v2/acme/tool/manifests/v1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+			t.Errorf("Accept header = %q", r.Header.Get("Accept"))
+		}
+		*calls++
+		w.Header().Set("Docker-Content-Digest", testDigest)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestRegistryResolverPinsAndCaches(t *testing.T) {
+	calls := 0
+	_, host := newTestRegistry(t, &calls)
+	resolver := NewRegistryResolver(RegistryResolverConfig{PlainHTTPRegistries: []string{host}})
+
+	for i := 0; i < 2; i++ {
+		got, err := resolver.Resolve(context.Background(), host+"/acme/tool:v1")
+		if err != nil {
+			t.Fatalf("Resolve error: %v", err)
+		}
+		if want := host + "/acme/tool@" + testDigest; got != want {
+			t.Fatalf("Resolve = %q, want %q", got, want)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("registry calls = %d, want 1", calls)
+	}
+}
+
+func TestRegistryResolverAllowlist(t *testing.T) {
+	calls := 0
+	_, host := newTestRegistry(t, &calls)
+	resolver := NewRegistryResolver(RegistryResolverConfig{
+		PlainHTTPRegistries: []string{host},
+		AllowedRepositories: []string{"ghcr.io/acme/"},
+	})
+
+	_, err := resolver.Resolve(context.Background(), host+"/acme/tool:v1")
+	if !errors.Is(err, ErrSecurityViolation) {
+		t.Fatalf("Resolve error = %v, want ErrSecurityViolation", err)
+	}
+	if calls != 0 {
+		t.Fatalf("registry contacted for disallowed repository")
+	}
+
+	resolver = NewRegistryResolver(RegistryResolverConfig{
+		PlainHTTPRegistries: []string{host},
+		AllowedRepositories: []string{host + "/acme/"},
+	})
+	if _, err := resolver.Resolve(context.Background(), host+"/acme/tool:v1"); err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+}
+
+func TestRegistryResolverDigestPolicy(t *testing.T) {
+	calls := 0
+	_, host := newTestRegistry(t, &calls)
+	resolver := NewRegistryResolver(RegistryResolverConfig{
+		PlainHTTPRegistries: []string{host},
+		AllowedDigests:      map[string][]string{host + "/acme/tool": {"sha256:0000"}},
+	})
+
+	if _, err := resolver.Resolve(context.Background(), host+"/acme/tool:v1"); !errors.Is(err, ErrSecurityViolation) {
+		t.Fatalf("Resolve error = %v, want ErrSecurityViolation", err)
+	}
+	got, err := resolver.Resolve(context.Background(), host+"/acme/tool@sha256:0000")
+	if err != nil {
+		t.Fatalf("Resolve pinned error: %v", err)
+	}
+	if got != host+"/acme/tool@sha256:0000" {
+		t.Fatalf("Resolve pinned = %q", got)
+	}
+}
+
+func TestRegistryResolverTokenChallenge(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if got := r.URL.Query().Get("scope"); got != "repository:acme/tool:pull" {
+				t.Errorf("scope = %q", got)
+			}
+			_, _ = w.Write([]byte(`{"token":"pull-token"}`))
+		case "/v2/acme/tool/manifests/v1":
+			if r.Header.Get("Authorization") != "Bearer pull-token" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="registry.test"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	resolver := NewRegistryResolver(RegistryResolverConfig{PlainHTTPRegistries: []string{host}})
+	got, err := resolver.Resolve(context.Background(), host+"/acme/tool:v1")
+	if err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+	if !strings.HasSuffix(got, "@"+testDigest) {
+		t.Fatalf("Resolve = %q", got)
+	}
+}
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		image, repo, tag string
+	}{
+		{"alpine", "docker.io/library/alpine", "latest"},
+		{"acme/tool:1.2", "docker.io/acme/tool", "1.2"},
+		{"localhost:5000/tool", "localhost:5000/tool", "latest"},
+		{"ghcr.io/acme/tool:v1@sha256:abc", "ghcr.io/acme/tool", "v1"},
+	}
+	for _, tt := range tests {
+		ref, err := parseImageRef(tt.image)
+		if err != nil {
+			t.Fatalf("parseImageRef(%q) error: %v", tt.image, err)
+		}
+		if ref.repository() != tt.repo || ref.tag != tt.tag {
+			t.Errorf("parseImageRef(%q) = %s:%s, want %s:%s", tt.image, ref.repository(), ref.tag, tt.repo, tt.tag)
+		}
+	}
+}
+
+func TestRegistryResolverEvictsExpiredEntries(t *testing.T) {
+	calls := 0
+	_, host := newTestRegistry(t, &calls)
+	resolver := NewRegistryResolver(RegistryResolverConfig{PlainHTTPRegistries: []string{host}, CacheTTL: time.Minute})
+	past := time.Now().Add(-time.Hour)
+	resolver.cache["example.com/old:v1"] = resolvedImage{digest: testDigest, expires: past}
+	resolver.cache["example.com/older:v1"] = resolvedImage{digest: testDigest, expires: past}
+
+	if _, err := resolver.Resolve(context.Background(), host+"/acme/tool:v1"); err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+	if len(resolver.cache) != 1 {
+		t.Fatalf("cache entries = %d, want 1 after eviction", len(resolver.cache))
+	}
+}
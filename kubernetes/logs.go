@@ -0,0 +1,93 @@
+package kubernetes
+
+import "fmt"
+
+// LogRetention selects which part of an oversized log is kept.
+type LogRetention string
+
+const (
+	// LogRetainHead keeps the first MaxLogBytes of output.
+	LogRetainHead LogRetention = "head"
+
+	// LogRetainTail keeps the last MaxLogBytes of output.
+	LogRetainTail LogRetention = "tail"
+
+	// LogRetainHeadTail keeps the first and last halves of MaxLogBytes,
+	// joined by a truncation marker.
+	LogRetainHeadTail LogRetention = "head-tail"
+)
+
+// RunResult is a PodResult with log accounting.
+type RunResult struct {
+	PodResult
+
+	// LogsTruncated reports whether Stdout was cut to fit MaxLogBytes.
+	LogsTruncated bool
+
+	// LogBytes is the total size of the log stream observed. With
+	// LogRetainHead the stream is cut on the server just past MaxLogBytes,
+	// so for truncated logs it is a lower bound.
+	LogBytes int64
+}
+
+// logCapture is an io.Writer that retains at most limit bytes of a stream
+// according to the retention mode, while counting everything written.
+type logCapture struct {
+	mode      LogRetention
+	headLimit int64
+	tailLimit int64
+
+	head  []byte
+	tail  []byte
+	total int64
+}
+
+func newLogCapture(limit int64, mode LogRetention) *logCapture {
+	c := &logCapture{mode: mode}
+	switch mode {
+	case LogRetainTail:
+		c.tailLimit = limit
+	case LogRetainHeadTail:
+		c.headLimit = limit / 2
+		c.tailLimit = limit - c.headLimit
+	default:
+		c.mode = LogRetainHead
+		c.headLimit = limit
+	}
+	return c
+}
+
+func (c *logCapture) Write(p []byte) (int, error) {
+	n := len(p)
+	c.total += int64(n)
+
+	if room := c.headLimit - int64(len(c.head)); room > 0 {
+		take := min(room, int64(len(p)))
+		c.head = append(c.head, p[:take]...)
+		p = p[take:]
+	}
+	if c.tailLimit > 0 && len(p) > 0 {
+		c.tail = append(c.tail, p...)
+		// Compact lazily so steady streaming stays amortized O(n).
+		if int64(len(c.tail)) > 2*c.tailLimit {
+			c.tail = append(c.tail[:0], c.tail[int64(len(c.tail))-c.tailLimit:]...)
+		}
+	}
+	return n, nil
+}
+
+func (c *logCapture) truncated() bool {
+	return c.total > c.headLimit+c.tailLimit
+}
+
+func (c *logCapture) String() string {
+	tail := c.tail
+	if int64(len(tail)) > c.tailLimit {
+		tail = tail[int64(len(tail))-c.tailLimit:]
+	}
+	if c.mode == LogRetainHeadTail && c.truncated() {
+		dropped := c.total - int64(len(c.head)) - int64(len(tail))
+		return string(c.head) + fmt.Sprintf("\n... [%d bytes truncated] ...\n", dropped) + string(tail)
+	}
+	return string(c.head) + string(tail)
+}
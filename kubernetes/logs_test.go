@@ -0,0 +1,112 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestLogCaptureRetention(t *testing.T) {
+	input := "0123456789abcdefghij"
+	tests := []struct {
+		mode LogRetention
+		want string
+	}{
+		{LogRetainHead, "01234567"},
+		{LogRetainTail, "cdefghij"},
+		{LogRetainHeadTail, "0123\n... [12 bytes truncated] ...\nghij"},
+	}
+	for _, tt := range tests {
+		capture := newLogCapture(8, tt.mode)
+		// Write in small chunks to exercise buffering across writes.
+		for i := 0; i < len(input); i += 3 {
+			_, _ = capture.Write([]byte(input[i:min(i+3, len(input))]))
+		}
+		if got := capture.String(); got != tt.want {
+			t.Errorf("%s: String() = %q, want %q", tt.mode, got, tt.want)
+		}
+		if !capture.truncated() || capture.total != int64(len(input)) {
+			t.Errorf("%s: truncated = %v, total = %d", tt.mode, capture.truncated(), capture.total)
+		}
+	}
+}
+
+func TestLogCaptureWithinLimit(t *testing.T) {
+	for _, mode := range []LogRetention{LogRetainHead, LogRetainTail, LogRetainHeadTail} {
+		capture := newLogCapture(64, mode)
+		_, _ = capture.Write([]byte("hello "))
+		_, _ = capture.Write([]byte("world"))
+		if capture.truncated() || capture.String() != "hello world" {
+			t.Errorf("%s: got %q (truncated=%v)", mode, capture.String(), capture.truncated())
+		}
+	}
+}
+
+func TestLogCaptureLargeTailStream(t *testing.T) {
+	capture := newLogCapture(1024, LogRetainTail)
+	chunk := []byte(strings.Repeat("x", 999) + "\n")
+	for i := 0; i < 10000; i++ {
+		_, _ = capture.Write(chunk)
+	}
+	if len(capture.tail) > 2*1024+len(chunk) {
+		t.Fatalf("tail buffer grew to %d bytes", len(capture.tail))
+	}
+	if got := capture.String(); len(got) != 1024 || !strings.HasSuffix(got, "x\n") {
+		t.Fatalf("unexpected tail of %d bytes", len(got))
+	}
+}
+
+func TestClientRunDetailedReportsTruncation(t *testing.T) {
+	clientset := newRunClientset(t)
+	client, err := NewClient(ClientConfig{
+		Clientset:    clientset,
+		PollInterval: time.Millisecond,
+		MaxLogBytes:  4,
+		LogRetention: LogRetainTail,
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	result, err := client.RunDetailed(context.Background(), PodSpec{Namespace: "default", Image: "toolruntime-sandbox:latest"})
+	if err != nil {
+		t.Fatalf("RunDetailed error: %v", err)
+	}
+	// The fake clientset always streams "fake logs".
+	if !result.LogsTruncated || result.LogBytes != int64(len("fake logs")) || result.Stdout != "logs" {
+		t.Fatalf("result = %+v", result)
+	}
+}
+
+func TestClientRunLimitsHeadLogStream(t *testing.T) {
+	clientset := newRunClientset(t)
+	client, err := NewClient(ClientConfig{
+		Clientset:    clientset,
+		PollInterval: time.Millisecond,
+		MaxLogBytes:  4,
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	result, err := client.RunDetailed(context.Background(), PodSpec{Namespace: "default", Image: "toolruntime-sandbox:latest"})
+	if err != nil {
+		t.Fatalf("RunDetailed error: %v", err)
+	}
+	if !result.LogsTruncated || result.Stdout != "fake" {
+		t.Fatalf("result = %+v", result)
+	}
+
+	var opts *corev1.PodLogOptions
+	for _, action := range clientset.Actions() {
+		if action.Matches("get", "pods") && action.GetSubresource() == "log" {
+			opts = action.(k8stesting.GenericAction).GetValue().(*corev1.PodLogOptions)
+		}
+	}
+	if opts == nil || opts.LimitBytes == nil || *opts.LimitBytes != 5 {
+		t.Fatalf("log options = %+v, want LimitBytes 5", opts)
+	}
+}
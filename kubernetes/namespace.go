@@ -0,0 +1,421 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// TenantLabel is the PodSpec label that selects a managed tenant namespace.
+	TenantLabel = "toolruntime.tenant"
+
+	managedNamespaceLabel = "toolruntime.managed"
+	lastUsedAnnotation    = "toolruntime.last-used"
+)
+
+// TenantNamespaceConfig enables per-tenant namespace provisioning.
+//
+// Runs whose PodSpec.Labels carry TenantLabel execute in a namespace
+// dedicated to that tenant instead of PodSpec.Namespace. The namespace is
+// created on first use with the baseline policies below.
+type TenantNamespaceConfig struct {
+	// Prefix is prepended to tenant namespace names.
+	// Default: toolrun-tenant
+	Prefix string
+
+	// Quota sets ResourceQuota hard limits; empty skips the quota.
+	Quota corev1.ResourceList
+
+	// DefaultLimits sets LimitRange container defaults; empty skips the LimitRange.
+	DefaultLimits corev1.ResourceList
+
+	// PodSecurityLevel is the enforced Pod Security Standard.
+	// Default: restricted
+	PodSecurityLevel string
+
+	// AllowEgress limits the default-deny NetworkPolicy to ingress only.
+	AllowEgress bool
+
+	// IdleTTL is how long a namespace may go unused before
+	// CollectIdleNamespaces deletes it. Zero disables collection.
+	IdleTTL time.Duration
+}
+
+// tenantNamespaces tracks provisioned tenant namespaces.
+type tenantNamespaces struct {
+	cfg TenantNamespaceConfig
+
+	mu      sync.Mutex
+	entries map[string]*tenantNamespace
+}
+
+type tenantNamespace struct {
+	ready    bool
+	active   int
+	lastUsed time.Time
+
+	// collecting is closed once CollectIdleNamespaces has finished deleting
+	// the namespace; runs wait for it instead of landing in a namespace
+	// that is being deleted.
+	collecting chan struct{}
+}
+
+func newTenantNamespaces(cfg *TenantNamespaceConfig) *tenantNamespaces {
+	if cfg == nil {
+		return nil
+	}
+	resolved := *cfg
+	if resolved.Prefix == "" {
+		resolved.Prefix = "toolrun-tenant"
+	}
+	if resolved.PodSecurityLevel == "" {
+		resolved.PodSecurityLevel = "restricted"
+	}
+	return &tenantNamespaces{cfg: resolved, entries: map[string]*tenantNamespace{}}
+}
+
+// acquireNamespace returns the namespace a run should use, provisioning the
+// tenant namespace when needed. The returned func marks the run finished.
+// The namespace's last-used annotation is refreshed when the run starts,
+// every IdleTTL/2 while it runs, and when it finishes, so collectors in
+// other clients see it as in use.
+func (c *Client) acquireNamespace(ctx context.Context, spec PodSpec) (string, func(), error) {
+	tenant := spec.Labels[TenantLabel]
+	if c.tenants == nil || tenant == "" {
+		return spec.Namespace, func() {}, nil
+	}
+	name := c.tenants.namespaceName(tenant)
+
+	c.tenants.mu.Lock()
+	entry, ok := c.tenants.entries[name]
+	for ok && entry.collecting != nil {
+		collecting := entry.collecting
+		c.tenants.mu.Unlock()
+		select {
+		case <-collecting:
+		case <-ctx.Done():
+			return "", nil, fmt.Errorf("%w: namespace %s: %w", ErrPodCreationFailed, name, ctx.Err())
+		}
+		c.tenants.mu.Lock()
+		entry, ok = c.tenants.entries[name]
+	}
+	if !ok {
+		entry = &tenantNamespace{}
+		c.tenants.entries[name] = entry
+	}
+	entry.active++
+	ready := entry.ready
+	c.tenants.mu.Unlock()
+
+	done := func() {
+		c.tenants.mu.Lock()
+		defer c.tenants.mu.Unlock()
+		entry.active--
+		entry.lastUsed = time.Now()
+	}
+
+	if !ready {
+		if err := c.provisionNamespace(ctx, name, tenant); err != nil {
+			done()
+			return "", nil, err
+		}
+		c.tenants.mu.Lock()
+		entry.ready = true
+		c.tenants.mu.Unlock()
+	} else {
+		c.touchNamespace(ctx, name, time.Now())
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	if ttl := c.tenants.cfg.IdleTTL; ttl > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(ttl / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case now := <-ticker.C:
+					c.touchNamespace(context.Background(), name, now)
+				}
+			}
+		}()
+	}
+	release := func() {
+		close(stop)
+		wg.Wait()
+		done()
+		c.touchNamespace(context.Background(), name, time.Now())
+	}
+	return name, release, nil
+}
+
+// forgetNamespace drops the cached readiness of name after a create in it
+// found the namespace missing or terminating, so the next run provisions
+// it again.
+func (c *Client) forgetNamespace(name string, err error) {
+	if c.tenants == nil || !(apierrors.IsNotFound(err) || apierrors.HasStatusCause(err, corev1.NamespaceTerminatingCause)) {
+		return
+	}
+	c.tenants.mu.Lock()
+	defer c.tenants.mu.Unlock()
+	if entry, ok := c.tenants.entries[name]; ok {
+		entry.ready = false
+	}
+}
+
+var invalidNamespaceChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+func (t *tenantNamespaces) namespaceName(tenant string) string {
+	slug := strings.Trim(invalidNamespaceChars.ReplaceAllString(strings.ToLower(tenant), "-"), "-")
+	name := t.cfg.Prefix + "-" + slug
+	if slug != tenant || len(name) > 63 {
+		sum := sha256.Sum256([]byte(tenant))
+		suffix := "-" + hex.EncodeToString(sum[:4])
+		if limit := 63 - len(suffix); len(name) > limit {
+			name = strings.TrimRight(name[:limit], "-")
+		}
+		name += suffix
+	}
+	return name
+}
+
+func (c *Client) provisionNamespace(ctx context.Context, name, tenant string) error {
+	cfg := c.tenants.cfg
+	labels := map[string]string{
+		managedNamespaceLabel:                        "true",
+		"pod-security.kubernetes.io/enforce":         cfg.PodSecurityLevel,
+		"pod-security.kubernetes.io/audit":           cfg.PodSecurityLevel,
+		"pod-security.kubernetes.io/warn":            cfg.PodSecurityLevel,
+		"pod-security.kubernetes.io/enforce-version": "latest",
+	}
+	if len(validation.IsValidLabelValue(tenant)) == 0 {
+		labels[TenantLabel] = tenant
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Labels:      labels,
+		Annotations: map[string]string{lastUsedAnnotation: time.Now().UTC().Format(time.RFC3339Nano)},
+	}}
+	if err := c.createNamespace(ctx, ns); err != nil {
+		return fmt.Errorf("%w: namespace %s: %w", ErrPodCreationFailed, name, err)
+	}
+
+	meta := metav1.ObjectMeta{Name: "toolruntime-baseline", Namespace: name}
+	if len(cfg.Quota) > 0 {
+		quota := &corev1.ResourceQuota{ObjectMeta: meta, Spec: corev1.ResourceQuotaSpec{Hard: cfg.Quota}}
+		if err := createIfMissing(c.clientset.CoreV1().ResourceQuotas(name).Create(ctx, quota, metav1.CreateOptions{})); err != nil {
+			return fmt.Errorf("%w: resource quota: %v", ErrPodCreationFailed, err)
+		}
+	}
+	if len(cfg.DefaultLimits) > 0 {
+		limits := &corev1.LimitRange{ObjectMeta: meta, Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+			Type:           corev1.LimitTypeContainer,
+			Default:        cfg.DefaultLimits,
+			DefaultRequest: cfg.DefaultLimits,
+		}}}}
+		if err := createIfMissing(c.clientset.CoreV1().LimitRanges(name).Create(ctx, limits, metav1.CreateOptions{})); err != nil {
+			return fmt.Errorf("%w: limit range: %v", ErrPodCreationFailed, err)
+		}
+	}
+
+	policyTypes := []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}
+	if !cfg.AllowEgress {
+		policyTypes = append(policyTypes, networkingv1.PolicyTypeEgress)
+	}
+	policy := &networkingv1.NetworkPolicy{ObjectMeta: meta, Spec: networkingv1.NetworkPolicySpec{PolicyTypes: policyTypes}}
+	if err := createIfMissing(c.clientset.NetworkingV1().NetworkPolicies(name).Create(ctx, policy, metav1.CreateOptions{})); err != nil {
+		return fmt.Errorf("%w: network policy: %v", ErrPodCreationFailed, err)
+	}
+
+	if c.logger != nil {
+		c.logger.Info("kubernetes tenant namespace ready", "namespace", name)
+	}
+	return nil
+}
+
+// createNamespace creates ns, or refreshes its last-used annotation if it
+// already exists. A namespace that is still terminating, for example after
+// a collection, is waited out and then created again.
+func (c *Client) createNamespace(ctx context.Context, ns *corev1.Namespace) error {
+	namespaces := c.clientset.CoreV1().Namespaces()
+	for {
+		_, err := namespaces.Create(ctx, ns, metav1.CreateOptions{})
+		if !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		existing, err := namespaces.Get(ctx, ns.Name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			continue
+		case err != nil:
+			return err
+		case existing.Status.Phase != corev1.NamespaceTerminating:
+			c.touchNamespace(ctx, ns.Name, time.Now())
+			return nil
+		}
+		if c.logger != nil {
+			c.logger.Info("kubernetes tenant namespace terminating, waiting", "namespace", ns.Name)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for terminating namespace: %w", ctx.Err())
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+func createIfMissing[T any](_ T, err error) error {
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// CollectIdleNamespaces deletes managed tenant namespaces that have had no
+// runs for longer than TenantNamespaceConfig.IdleTTL. Call it periodically;
+// it is a no-op when tenant namespaces or IdleTTL are not configured. Runs
+// for a namespace wait while it is being deleted. A namespace is kept if it
+// still has active Jobs or Pods, for example from another client, or if
+// another client touched it since it was listed.
+func (c *Client) CollectIdleNamespaces(ctx context.Context) error {
+	if c.tenants == nil || c.tenants.cfg.IdleTTL <= 0 {
+		return nil
+	}
+	if c.clientset == nil {
+		return ErrClientNotConfigured
+	}
+	list, err := c.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: managedNamespaceLabel + "=true"})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, ns := range list.Items {
+		if ns.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		lastUsed, _ := time.Parse(time.RFC3339, ns.Annotations[lastUsedAnnotation])
+
+		c.tenants.mu.Lock()
+		entry, ok := c.tenants.entries[ns.Name]
+		if ok && entry.active > 0 {
+			c.tenants.mu.Unlock()
+			continue
+		}
+		var localUse time.Time
+		if ok {
+			localUse = entry.lastUsed
+		}
+		if localUse.After(lastUsed) {
+			lastUsed = localUse
+		}
+		if now.Sub(lastUsed) < c.tenants.cfg.IdleTTL {
+			c.tenants.mu.Unlock()
+			if !localUse.IsZero() {
+				c.touchNamespace(ctx, ns.Name, localUse)
+			}
+			continue
+		}
+		if !ok {
+			entry = &tenantNamespace{}
+			c.tenants.entries[ns.Name] = entry
+		}
+		entry.collecting = make(chan struct{})
+		c.tenants.mu.Unlock()
+
+		busy, err := c.namespaceBusy(ctx, ns.Name)
+		if busy || err != nil {
+			c.tenants.mu.Lock()
+			close(entry.collecting)
+			entry.collecting = nil
+			c.tenants.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = c.clientset.CoreV1().Namespaces().Delete(ctx, ns.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &ns.UID, ResourceVersion: &ns.ResourceVersion},
+		})
+		deleted := err == nil || apierrors.IsNotFound(err)
+
+		c.tenants.mu.Lock()
+		if deleted {
+			delete(c.tenants.entries, ns.Name)
+		}
+		close(entry.collecting)
+		entry.collecting = nil
+		c.tenants.mu.Unlock()
+
+		if apierrors.IsConflict(err) {
+			// Used elsewhere since it was listed.
+			continue
+		}
+		if !deleted {
+			return err
+		}
+		if c.logger != nil {
+			c.logger.Info("kubernetes idle tenant namespace deleted", "namespace", ns.Name)
+		}
+	}
+	return nil
+}
+
+// namespaceBusy reports whether name has unfinished Jobs or Pods.
+func (c *Client) namespaceBusy(ctx context.Context, name string) (bool, error) {
+	jobs, err := c.clientset.BatchV1().Jobs(name).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, job := range jobs.Items {
+		if job.Status.Succeeded == 0 && job.Status.Failed == 0 {
+			return true, nil
+		}
+	}
+	pods, err := c.clientset.CoreV1().Pods(name).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodPending || pod.Status.Phase == corev1.PodRunning {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// touchNamespace records last use on the namespace so other clients (or a
+// restarted one) see it as active.
+func (c *Client) touchNamespace(ctx context.Context, name string, lastUsed time.Time) {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{lastUsedAnnotation: lastUsed.UTC().Format(time.RFC3339Nano)},
+		},
+	})
+	if err != nil {
+		return
+	}
+	if _, err := c.clientset.CoreV1().Namespaces().Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil && c.logger != nil {
+		c.logger.Warn("kubernetes tenant namespace touch failed", "namespace", name, "error", err)
+	}
+}
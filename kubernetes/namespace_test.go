@@ -0,0 +1,315 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestClientRunProvisionsTenantNamespace(t *testing.T) {
+	clientset := newRunClientset(t)
+	var jobNamespace string
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		jobNamespace = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).Namespace
+		return false, nil, nil
+	})
+
+	client, err := NewClient(ClientConfig{
+		Clientset:    clientset,
+		PollInterval: time.Millisecond,
+		TenantNamespaces: &TenantNamespaceConfig{
+			Quota:         corev1.ResourceList{corev1.ResourcePods: resource.MustParse("10")},
+			DefaultLimits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	spec := PodSpec{
+		Namespace: "default",
+		Image:     "toolruntime-sandbox:latest",
+		Labels:    map[string]string{TenantLabel: "acme"},
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.Run(context.Background(), spec); err != nil {
+			t.Fatalf("Run error: %v", err)
+		}
+	}
+
+	if jobNamespace != "toolrun-tenant-acme" {
+		t.Fatalf("job namespace = %q", jobNamespace)
+	}
+
+	ctx := context.Background()
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, jobNamespace, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get namespace: %v", err)
+	}
+	if ns.Labels["pod-security.kubernetes.io/enforce"] != "restricted" || ns.Labels[TenantLabel] != "acme" {
+		t.Fatalf("namespace labels = %v", ns.Labels)
+	}
+	if _, err := clientset.CoreV1().ResourceQuotas(jobNamespace).Get(ctx, "toolruntime-baseline", metav1.GetOptions{}); err != nil {
+		t.Fatalf("get quota: %v", err)
+	}
+	if _, err := clientset.CoreV1().LimitRanges(jobNamespace).Get(ctx, "toolruntime-baseline", metav1.GetOptions{}); err != nil {
+		t.Fatalf("get limit range: %v", err)
+	}
+	policy, err := clientset.NetworkingV1().NetworkPolicies(jobNamespace).Get(ctx, "toolruntime-baseline", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get network policy: %v", err)
+	}
+	if len(policy.Spec.PolicyTypes) != 2 {
+		t.Fatalf("policy types = %v, want ingress and egress", policy.Spec.PolicyTypes)
+	}
+
+	creates := 0
+	for _, action := range clientset.Actions() {
+		if action.Matches("create", "namespaces") {
+			creates++
+		}
+	}
+	if creates != 1 {
+		t.Fatalf("namespace created %d times, want 1 (readiness should be cached)", creates)
+	}
+}
+
+func TestCollectIdleNamespaces(t *testing.T) {
+	clientset := newRunClientset(t)
+	client, err := NewClient(ClientConfig{
+		Clientset:        clientset,
+		PollInterval:     time.Millisecond,
+		TenantNamespaces: &TenantNamespaceConfig{IdleTTL: 200 * time.Millisecond},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	ctx := context.Background()
+	name, release, err := client.acquireNamespace(ctx, PodSpec{Labels: map[string]string{TenantLabel: "acme"}})
+	if err != nil {
+		t.Fatalf("acquireNamespace error: %v", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if err := client.CollectIdleNamespaces(ctx); err != nil {
+		t.Fatalf("CollectIdleNamespaces error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("namespace with an active run was collected: %v", err)
+	}
+
+	release()
+	if err := client.CollectIdleNamespaces(ctx); err != nil {
+		t.Fatalf("CollectIdleNamespaces error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("recently used namespace was collected: %v", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if err := client.CollectIdleNamespaces(ctx); err != nil {
+		t.Fatalf("CollectIdleNamespaces error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{}); err == nil {
+		t.Fatal("expected idle namespace to be deleted")
+	}
+}
+
+func TestAcquireNamespaceWaitsForCollection(t *testing.T) {
+	clientset := newRunClientset(t)
+	deleting, proceed := make(chan struct{}), make(chan struct{})
+	clientset.PrependReactor("delete", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+		close(deleting)
+		<-proceed
+		return false, nil, nil
+	})
+	client, err := NewClient(ClientConfig{
+		Clientset:        clientset,
+		TenantNamespaces: &TenantNamespaceConfig{IdleTTL: time.Millisecond},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	ctx := context.Background()
+	spec := PodSpec{Labels: map[string]string{TenantLabel: "acme"}}
+	_, release, err := client.acquireNamespace(ctx, spec)
+	if err != nil {
+		t.Fatalf("acquireNamespace error: %v", err)
+	}
+	release()
+	time.Sleep(5 * time.Millisecond)
+
+	collected := make(chan error, 1)
+	go func() { collected <- client.CollectIdleNamespaces(ctx) }()
+	<-deleting
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, release, err := client.acquireNamespace(ctx, spec)
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("acquireNamespace returned during collection: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(proceed)
+	if err := <-collected; err != nil {
+		t.Fatalf("CollectIdleNamespaces error: %v", err)
+	}
+	if err := <-acquired; err != nil {
+		t.Fatalf("acquireNamespace error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Namespaces().Get(ctx, "toolrun-tenant-acme", metav1.GetOptions{}); err != nil {
+		t.Fatalf("namespace not re-provisioned after collection: %v", err)
+	}
+}
+
+func TestAcquireNamespaceWaitsOutTerminatingNamespace(t *testing.T) {
+	clientset := newRunClientset(t)
+	ctx := context.Background()
+	_, err := clientset.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "toolrun-tenant-acme"},
+		Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("create namespace: %v", err)
+	}
+	client, err := NewClient(ClientConfig{Clientset: clientset, PollInterval: time.Millisecond, TenantNamespaces: &TenantNamespaceConfig{}}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = clientset.CoreV1().Namespaces().Delete(ctx, "toolrun-tenant-acme", metav1.DeleteOptions{})
+	}()
+
+	_, release, err := client.acquireNamespace(ctx, PodSpec{Labels: map[string]string{TenantLabel: "acme"}})
+	if err != nil {
+		t.Fatalf("acquireNamespace error: %v", err)
+	}
+	release()
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, "toolrun-tenant-acme", metav1.GetOptions{})
+	if err != nil || ns.Status.Phase == corev1.NamespaceTerminating {
+		t.Fatalf("namespace not re-created: %v, %#v", err, ns)
+	}
+}
+
+func TestCollectIdleNamespacesAcrossClients(t *testing.T) {
+	clientset := newRunClientset(t)
+	ctx := context.Background()
+	cfg := ClientConfig{Clientset: clientset, PollInterval: time.Millisecond, TenantNamespaces: &TenantNamespaceConfig{IdleTTL: 50 * time.Millisecond}}
+	runner, err := NewClient(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	collector, err := NewClient(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	name, release, err := runner.acquireNamespace(ctx, PodSpec{Labels: map[string]string{TenantLabel: "acme"}})
+	if err != nil {
+		t.Fatalf("acquireNamespace error: %v", err)
+	}
+
+	// The runner keeps the annotation fresh while its run is active.
+	time.Sleep(120 * time.Millisecond)
+	if err := collector.CollectIdleNamespaces(ctx); err != nil {
+		t.Fatalf("CollectIdleNamespaces error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("namespace in use by another client was collected: %v", err)
+	}
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "toolrun-1", Namespace: name}}
+	if _, err := clientset.BatchV1().Jobs(name).Create(ctx, job, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+
+	// An active Job keeps the namespace even once the annotation is stale.
+	release()
+	time.Sleep(80 * time.Millisecond)
+	if err := collector.CollectIdleNamespaces(ctx); err != nil {
+		t.Fatalf("CollectIdleNamespaces error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("namespace with an active job was collected: %v", err)
+	}
+
+	job.Status.Succeeded = 1
+	if _, err := clientset.BatchV1().Jobs(name).UpdateStatus(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update job: %v", err)
+	}
+	if err := collector.CollectIdleNamespaces(ctx); err != nil {
+		t.Fatalf("CollectIdleNamespaces error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{}); err == nil {
+		t.Fatal("expected idle namespace to be deleted")
+	}
+}
+
+func TestClientRunReprovisionsMissingNamespace(t *testing.T) {
+	clientset := newRunClientset(t)
+	var failed bool
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if !failed {
+			failed = true
+			return true, nil, apierrors.NewNotFound(corev1.Resource("namespaces"), action.GetNamespace())
+		}
+		return false, nil, nil
+	})
+	client, err := NewClient(ClientConfig{
+		Clientset:        clientset,
+		PollInterval:     time.Millisecond,
+		TenantNamespaces: &TenantNamespaceConfig{},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	spec := PodSpec{Namespace: "default", Image: "toolruntime-sandbox:latest", Labels: map[string]string{TenantLabel: "acme"}}
+	if _, err := client.Run(context.Background(), spec); err == nil {
+		t.Fatal("expected Run to fail while the namespace is missing")
+	}
+	if _, err := client.Run(context.Background(), spec); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	creates := 0
+	for _, action := range clientset.Actions() {
+		if action.Matches("create", "namespaces") {
+			creates++
+		}
+	}
+	if creates != 2 {
+		t.Fatalf("namespace created %d times, want 2", creates)
+	}
+}
+
+func TestTenantNamespaceName(t *testing.T) {
+	tenants := newTenantNamespaces(&TenantNamespaceConfig{})
+	if got := tenants.namespaceName("acme"); got != "toolrun-tenant-acme" {
+		t.Fatalf("namespaceName(acme) = %q", got)
+	}
+	got := tenants.namespaceName("Acme Corp/" + strings.Repeat("x", 80))
+	if len(got) > 63 || strings.ToLower(got) != got || strings.Contains(got, " ") {
+		t.Fatalf("namespaceName produced invalid name %q", got)
+	}
+	if tenants.namespaceName("Acme") == tenants.namespaceName("acme") {
+		t.Fatal("distinct tenants mapped to the same namespace")
+	}
+}
@@ -0,0 +1,226 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// With ClientConfig.EnvReferences set, entries in PodSpec.Env may reference
+// Kubernetes objects instead of carrying literal values:
+//
+//	NAME=secret://<secret>/<key>       valueFrom.secretKeyRef
+//	NAME=configmap://<configmap>/<key> valueFrom.configMapKeyRef
+//	NAME=secretfile://<secret>/<key>   key mounted read-only; NAME holds the file path
+//
+// Referenced values never appear in the Job object. Without EnvReferences,
+// such values are passed through literally.
+const (
+	secretRefScheme     = "secret://"
+	configMapRefScheme  = "configmap://"
+	secretFileRefScheme = "secretfile://"
+
+	// SecretMountRoot is the directory under which secretfile:// references are mounted.
+	SecretMountRoot = "/var/run/toolruntime/secrets"
+)
+
+// podEnv is the container environment derived from PodSpec.Env.
+type podEnv struct {
+	vars    []corev1.EnvVar
+	volumes []corev1.Volume
+	mounts  []corev1.VolumeMount
+}
+
+func toPodEnv(env []string, refs bool) (podEnv, error) {
+	var out podEnv
+	secretVolumes := map[string]int{}
+	for _, item := range env {
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			continue
+		}
+
+		switch {
+		case !refs:
+			out.vars = append(out.vars, corev1.EnvVar{Name: name, Value: value})
+		case strings.HasPrefix(value, secretRefScheme):
+			obj, key, err := parseEnvRef(name, value, secretRefScheme)
+			if err != nil {
+				return podEnv{}, err
+			}
+			out.vars = append(out.vars, corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: obj},
+					Key:                  key,
+				},
+			}})
+		case strings.HasPrefix(value, configMapRefScheme):
+			obj, key, err := parseEnvRef(name, value, configMapRefScheme)
+			if err != nil {
+				return podEnv{}, err
+			}
+			out.vars = append(out.vars, corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{
+				ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: obj},
+					Key:                  key,
+				},
+			}})
+		case strings.HasPrefix(value, secretFileRefScheme):
+			obj, key, err := parseEnvRef(name, value, secretFileRefScheme)
+			if err != nil {
+				return podEnv{}, err
+			}
+			idx, ok := secretVolumes[obj]
+			if !ok {
+				idx = len(out.volumes)
+				secretVolumes[obj] = idx
+				volumeName := fmt.Sprintf("secret-%d", idx)
+				out.volumes = append(out.volumes, corev1.Volume{
+					Name: volumeName,
+					VolumeSource: corev1.VolumeSource{
+						Secret: &corev1.SecretVolumeSource{SecretName: obj},
+					},
+				})
+				out.mounts = append(out.mounts, corev1.VolumeMount{
+					Name:      volumeName,
+					MountPath: path.Join(SecretMountRoot, obj),
+					ReadOnly:  true,
+				})
+			}
+			source := out.volumes[idx].Secret
+			if !slices.ContainsFunc(source.Items, func(item corev1.KeyToPath) bool { return item.Key == key }) {
+				source.Items = append(source.Items, corev1.KeyToPath{Key: key, Path: key})
+			}
+			out.vars = append(out.vars, corev1.EnvVar{Name: name, Value: path.Join(SecretMountRoot, obj, key)})
+		default:
+			out.vars = append(out.vars, corev1.EnvVar{Name: name, Value: value})
+		}
+	}
+	return out, nil
+}
+
+func parseEnvRef(name, value, scheme string) (string, string, error) {
+	obj, key, ok := strings.Cut(strings.TrimPrefix(value, scheme), "/")
+	if !ok || obj == "" || key == "" || strings.Contains(key, "/") || key == "." || key == ".." {
+		return "", "", fmt.Errorf("%w: env %s: invalid reference %q (want %s<name>/<key>)", ErrPodCreationFailed, name, value, scheme)
+	}
+	return obj, key, nil
+}
+
+// sensitiveName matches env and log keys whose values must not be logged.
+var sensitiveName = regexp.MustCompile(`(?i)(secret|token|passw(or)?d|credential|api[_-]?key|private[_-]?key)`)
+
+// sensitiveEnvValues returns literal env values whose names look sensitive.
+// refs reports whether reference values are resolved rather than literal.
+func sensitiveEnvValues(env []string, refs bool) []string {
+	var values []string
+	for _, item := range env {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || value == "" || !sensitiveName.MatchString(name) {
+			continue
+		}
+		if refs && (strings.HasPrefix(value, secretRefScheme) || strings.HasPrefix(value, configMapRefScheme) || strings.HasPrefix(value, secretFileRefScheme)) {
+			continue
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+const redacted = "[REDACTED]"
+
+// minRedactLen is the shortest registered value scrubbed from inside
+// longer log text. Shorter values would match unrelated substrings, mangling
+// logs and hinting at the secret, so they are only redacted as whole values.
+const minRedactLen = 4
+
+// redactingLogger scrubs secret values from log arguments. Values logged
+// under sensitive keys are always redacted; registered values are replaced
+// wherever they appear in string or error arguments, or only where they are
+// the entire argument when shorter than minRedactLen.
+type redactingLogger struct {
+	next Logger
+
+	mu     sync.RWMutex
+	values map[string]int
+}
+
+func newRedactingLogger(next Logger) *redactingLogger {
+	if next == nil {
+		return nil
+	}
+	return &redactingLogger{next: next, values: map[string]int{}}
+}
+
+// track registers values for redaction until the returned func is called.
+func (l *redactingLogger) track(values []string) func() {
+	if l == nil || len(values) == 0 {
+		return func() {}
+	}
+	l.mu.Lock()
+	for _, v := range values {
+		l.values[v]++
+	}
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, v := range values {
+			if l.values[v]--; l.values[v] <= 0 {
+				delete(l.values, v)
+			}
+		}
+	}
+}
+
+func (l *redactingLogger) Info(msg string, args ...any)  { l.next.Info(msg, l.redact(args)...) }
+func (l *redactingLogger) Warn(msg string, args ...any)  { l.next.Warn(msg, l.redact(args)...) }
+func (l *redactingLogger) Error(msg string, args ...any) { l.next.Error(msg, l.redact(args)...) }
+
+func (l *redactingLogger) redact(args []any) []any {
+	out := make([]any, len(args))
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for i, arg := range args {
+		if i%2 == 1 {
+			if key, ok := args[i-1].(string); ok && sensitiveName.MatchString(key) {
+				out[i] = redacted
+				continue
+			}
+		}
+		switch v := arg.(type) {
+		case string:
+			out[i] = l.scrub(v)
+		case error:
+			if scrubbed := l.scrub(v.Error()); scrubbed != v.Error() {
+				out[i] = errors.New(scrubbed)
+			} else {
+				out[i] = v
+			}
+		default:
+			out[i] = arg
+		}
+	}
+	return out
+}
+
+func (l *redactingLogger) scrub(s string) string {
+	if _, ok := l.values[s]; ok {
+		return redacted
+	}
+	for v := range l.values {
+		if len(v) >= minRedactLen {
+			s = strings.ReplaceAll(s, v, redacted)
+		}
+	}
+	return s
+}
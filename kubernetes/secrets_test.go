@@ -0,0 +1,159 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestToPodEnvReferences(t *testing.T) {
+	env, err := toPodEnv([]string{
+		"MODE=test",
+		"API_TOKEN=secret://api-creds/token",
+		"REGION=configmap://settings/region",
+		"TLS_KEY=secretfile://tls/key.pem",
+		"TLS_CERT=secretfile://tls/cert.pem",
+		"TLS_KEY_PATH=secretfile://tls/key.pem",
+	}, true)
+	if err != nil {
+		t.Fatalf("toPodEnv error: %v", err)
+	}
+	if len(env.vars) != 6 {
+		t.Fatalf("vars = %d, want 6", len(env.vars))
+	}
+	if env.vars[0].Value != "test" {
+		t.Errorf("literal value = %q", env.vars[0].Value)
+	}
+	if ref := env.vars[1].ValueFrom.SecretKeyRef; ref == nil || ref.Name != "api-creds" || ref.Key != "token" || env.vars[1].Value != "" {
+		t.Errorf("secret ref = %#v", env.vars[1])
+	}
+	if ref := env.vars[2].ValueFrom.ConfigMapKeyRef; ref == nil || ref.Name != "settings" || ref.Key != "region" {
+		t.Errorf("configmap ref = %#v", env.vars[2])
+	}
+	if got := env.vars[3].Value; got != SecretMountRoot+"/tls/key.pem" {
+		t.Errorf("secret file path = %q", got)
+	}
+	if len(env.volumes) != 1 || len(env.volumes[0].Secret.Items) != 2 {
+		t.Fatalf("volumes = %#v", env.volumes)
+	}
+	if len(env.mounts) != 1 || !env.mounts[0].ReadOnly || env.mounts[0].MountPath != SecretMountRoot+"/tls" {
+		t.Fatalf("mounts = %#v", env.mounts)
+	}
+}
+
+func TestToPodEnvRejectsMalformedReference(t *testing.T) {
+	for _, item := range []string{"A=secret://only-name", "A=secretfile://tls/../x", "A=configmap:///key"} {
+		if _, err := toPodEnv([]string{item}, true); !errors.Is(err, ErrPodCreationFailed) {
+			t.Errorf("toPodEnv(%q) error = %v, want ErrPodCreationFailed", item, err)
+		}
+	}
+}
+
+func TestToPodEnvLiteralWithoutReferences(t *testing.T) {
+	env, err := toPodEnv([]string{"URL=secret://not-a-ref/value"}, false)
+	if err != nil {
+		t.Fatalf("toPodEnv error: %v", err)
+	}
+	if len(env.vars) != 1 || env.vars[0].Value != "secret://not-a-ref/value" || env.vars[0].ValueFrom != nil || len(env.volumes) != 0 {
+		t.Fatalf("env = %#v", env)
+	}
+	if values := sensitiveEnvValues([]string{"API_TOKEN=secret://x/y"}, false); len(values) != 1 {
+		t.Fatalf("literal sensitive value not tracked: %v", values)
+	}
+}
+
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(msg string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, msg+" "+fmt.Sprint(args...))
+}
+
+func (l *recordingLogger) Info(msg string, args ...any)  { l.record(msg, args...) }
+func (l *recordingLogger) Warn(msg string, args ...any)  { l.record(msg, args...) }
+func (l *recordingLogger) Error(msg string, args ...any) { l.record(msg, args...) }
+
+func TestRedactingLogger(t *testing.T) {
+	rec := &recordingLogger{}
+	logger := newRedactingLogger(rec)
+	done := logger.track(sensitiveEnvValues([]string{"DB_PASSWORD=hunter2", "MODE=hunter3"}, true))
+
+	logger.Warn("create failed", "error", errors.New("invalid value hunter2"), "token", "abc", "mode", "hunter3")
+	done()
+	logger.Info("after run", "value", "hunter2")
+
+	if len(rec.lines) != 2 {
+		t.Fatalf("lines = %d, want 2", len(rec.lines))
+	}
+	during := rec.lines[0]
+	if strings.Contains(during, "hunter2") || strings.Contains(during, "abc") {
+		t.Fatalf("secret leaked into log output: %s", during)
+	}
+	if !strings.Contains(during, "hunter3") {
+		t.Fatalf("non-sensitive value was redacted: %s", during)
+	}
+	if !strings.Contains(rec.lines[1], "hunter2") {
+		t.Fatalf("value should no longer be redacted after run: %s", rec.lines[1])
+	}
+}
+
+func TestRedactingLoggerShortValues(t *testing.T) {
+	rec := &recordingLogger{}
+	logger := newRedactingLogger(rec)
+	done := logger.track(sensitiveEnvValues([]string{"DB_PASSWORD=dev"}, true))
+	defer done()
+
+	logger.Warn("pod pending", "reason", "device plugin not ready", "value", "dev")
+
+	if len(rec.lines) != 1 {
+		t.Fatalf("lines = %d, want 1", len(rec.lines))
+	}
+	line := rec.lines[0]
+	if !strings.Contains(line, "device plugin not ready") {
+		t.Fatalf("short secret mangled unrelated text: %s", line)
+	}
+	if !strings.HasSuffix(line, "value"+redacted) {
+		t.Fatalf("short secret logged as a whole value was not redacted: %s", line)
+	}
+}
+
+func TestClientRunKeepsSecretsOutOfJob(t *testing.T) {
+	clientset := newRunClientset(t)
+	var job *batchv1.Job
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).DeepCopy()
+		return false, nil, nil
+	})
+
+	client, err := NewClient(ClientConfig{Clientset: clientset, PollInterval: time.Millisecond, EnvReferences: true}, nil)
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	if _, err := client.Run(context.Background(), PodSpec{
+		Namespace: "default",
+		Image:     "toolruntime-sandbox:latest",
+		Env:       []string{"API_TOKEN=secret://api-creds/token", "CA=secretfile://ca/ca.crt"},
+	}); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+
+	podSpec := job.Spec.Template.Spec
+	if ref := podSpec.Containers[0].Env[0].ValueFrom; ref == nil || ref.SecretKeyRef == nil {
+		t.Fatalf("expected secretKeyRef, got %#v", podSpec.Containers[0].Env[0])
+	}
+	if len(podSpec.Volumes) != 1 || len(podSpec.Containers[0].VolumeMounts) != 1 {
+		t.Fatalf("expected secret volume and mount, got %#v / %#v", podSpec.Volumes, podSpec.Containers[0].VolumeMounts)
+	}
+}
@@ -2,7 +2,6 @@ package proxmox
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jonwraymond/toolexec-integrations/internal/tlsconfig"
 	coreproxmox "github.com/jonwraymond/toolexec/runtime/backend/proxmox"
 )
 
@@ -18,6 +18,11 @@ type APIClient = coreproxmox.APIClient
 type LXCStatus = coreproxmox.LXCStatus
 type Logger = coreproxmox.Logger
 
+// TLSConfig configures TLS for the API connection. Proxmox serves a
+// self-signed certificate by default; pin its fingerprint or set the
+// cluster CA instead of disabling verification.
+type TLSConfig = tlsconfig.Config
+
 var (
 	ErrProxmoxNotAvailable = coreproxmox.ErrProxmoxNotAvailable
 	ErrAuthNotConfigured   = coreproxmox.ErrAuthNotConfigured
@@ -37,6 +42,11 @@ type ClientConfig struct {
 	// TLSSkipVerify disables TLS verification (dev only).
 	TLSSkipVerify bool
 
+	// TLS configures CA bundles, an mTLS client certificate, server-name
+	// override, certificate pinning and reload of rotated certificate files
+	// for connections to the Proxmox API. Ignored if HTTPClient is set.
+	TLS TLSConfig
+
 	// HTTPClient overrides the default HTTP client.
 	HTTPClient *http.Client
 
@@ -71,9 +81,9 @@ func NewClient(cfg ClientConfig, logger Logger) (*Client, error) {
 		if timeout == 0 {
 			timeout = 30 * time.Second
 		}
-		transport := http.DefaultTransport.(*http.Transport).Clone()
-		if cfg.TLSSkipVerify {
-			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // #nosec G402 -- explicitly opt-in for local/test endpoints
+		transport, err := tlsconfig.Transport(http.DefaultTransport.(*http.Transport).Clone(), cfg.TLS, cfg.TLSSkipVerify)
+		if err != nil {
+			return nil, fmt.Errorf("tls config: %w", err)
 		}
 		client = &http.Client{
 			Transport: transport,
@@ -2,9 +2,12 @@ package proxmox
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -47,3 +50,29 @@ func TestClientRequiresToken(t *testing.T) {
 		t.Fatal("expected error")
 	}
 }
+
+func TestClientPinnedSelfSignedCertificate(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": map[string]any{"status": "stopped"},
+		})
+	}))
+	defer srv.Close()
+	sum := sha256.Sum256(srv.Certificate().Raw)
+
+	for _, pin := range []string{hex.EncodeToString(sum[:]), strings.Repeat("00", sha256.Size)} {
+		client, err := NewClient(ClientConfig{
+			Endpoint:    srv.URL + "/api2/json",
+			TokenID:     "user@pam!token",
+			TokenSecret: "secret",
+			TLS:         TLSConfig{PinnedSHA256: []string{pin}},
+		}, nil)
+		if err != nil {
+			t.Fatalf("NewClient error: %v", err)
+		}
+		_, err = client.Status(context.Background(), "node-1", 100)
+		if matching := pin == hex.EncodeToString(sum[:]); matching != (err == nil) {
+			t.Fatalf("pin %s: Status error = %v", pin, err)
+		}
+	}
+}
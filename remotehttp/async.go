@@ -0,0 +1,144 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// Async execution headers.
+//
+// Server contract: with Config.Async, non-streamed Execute requests carry
+//
+//	Prefer: respond-async
+//
+// A server that supports it starts the execution and answers
+// 202 Accepted with a Location header naming a status URL on the same
+// host as the endpoint, and ExecutionIDHeader. The client then polls
+//
+//	GET <status URL>
+//	Prefer: wait=<seconds>
+//
+// (signed like the original request). The server may hold the poll open
+// up to the requested wait. It answers 202, optionally with Retry-After,
+// while the execution runs, and 200 with the usual JSON RemoteResponse once
+// it has finished; 404 or 410 mean the execution is gone. A server that
+// ignores the preference answers 200 right away as before. Canceling the
+// caller's context sends the cancel request described at EventExecution.
+const (
+	PreferHeader       = "Prefer"
+	preferRespondAsync = "respond-async"
+)
+
+// asyncJob is an accepted async execution.
+type asyncJob struct {
+	endpoint    *url.URL
+	statusURL   string
+	executionID string
+}
+
+// acceptAsync validates a 202 response to an async submit.
+func (c *Client) acceptAsync(resp *http.Response, endpoint *url.URL) (asyncJob, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return asyncJob{}, fmt.Errorf("%w: async response has no Location", remote.ErrRemoteExecutionFailed)
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return asyncJob{}, fmt.Errorf("%w: async status URL: %v", remote.ErrRemoteExecutionFailed, err)
+	}
+	status := endpoint.ResolveReference(ref)
+	// Polls carry credentials; never send them to another origin.
+	if status.Scheme != endpoint.Scheme || status.Host != endpoint.Host {
+		return asyncJob{}, fmt.Errorf("%w: async status URL %q is not on the endpoint host", remote.ErrRemoteExecutionFailed, status.Redacted())
+	}
+	return asyncJob{endpoint: endpoint, statusURL: status.String(), executionID: resp.Header.Get(ExecutionIDHeader)}, nil
+}
+
+// pollAsync polls job until it finishes. Up to MaxRetries consecutive
+// failed polls are retried.
+func (c *Client) pollAsync(ctx context.Context, call *call, job asyncJob) (remote.RemoteResponse, error) {
+	failures := 0
+	for {
+		response, done, retryAfter, err := c.pollOnce(ctx, job)
+		var delay time.Duration
+		switch {
+		case ctx.Err() != nil:
+			c.cancelAbandoned(ctx, job.endpoint, job.executionID, false)
+			return remote.RemoteResponse{}, fmt.Errorf("%w: %w", remote.ErrRemoteExecutionFailed, ctx.Err())
+		case err != nil:
+			failures++
+			if failures > c.maxRetries {
+				return remote.RemoteResponse{}, err
+			}
+			var ok bool
+			if delay, ok = c.retry.Backoff(failures, err); !ok {
+				return remote.RemoteResponse{}, err
+			}
+			if c.logger != nil {
+				c.logger.Warn("remote execution poll retry", "execution_id", job.executionID, "attempt", failures, "delay", delay, "error", err)
+			}
+		case done:
+			emitResponse(call, response)
+			return response, nil
+		default:
+			failures = 0
+			delay = c.pollInterval
+			if retryAfter > 0 {
+				delay = retryAfter
+			}
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			c.cancelAbandoned(ctx, job.endpoint, job.executionID, false)
+			return remote.RemoteResponse{}, fmt.Errorf("%w: %w", remote.ErrRemoteExecutionFailed, err)
+		}
+	}
+}
+
+// pollOnce fetches the status of job. done reports whether response holds
+// the final outcome; otherwise retryAfter is the server's hint, if any.
+func (c *Client) pollOnce(ctx context.Context, job asyncJob) (response remote.RemoteResponse, done bool, retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, job.statusURL, nil)
+	if err != nil {
+		return response, false, 0, fmt.Errorf("%w: build request: %v", remote.ErrConnectionFailed, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.pollWait > 0 {
+		req.Header.Set(PreferHeader, "wait="+strconv.FormatInt(int64(math.Ceil(c.pollWait.Seconds())), 10))
+	}
+	resp, err := c.do(req, nil)
+	if err != nil {
+		return response, false, 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch {
+	case resp.StatusCode == http.StatusAccepted:
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+		return response, false, parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()), nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return response, false, 0, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(body)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return response, false, 0, fmt.Errorf("%w: read response: %v", remote.ErrConnectionFailed, err)
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return response, false, 0, fmt.Errorf("%w: decode response: %v", remote.ErrRemoteExecutionFailed, err)
+	}
+	return response, true, 0, nil
+}
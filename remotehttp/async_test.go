@@ -0,0 +1,137 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// asyncServer accepts jobs and reports them as running for pending polls.
+type asyncServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	posts    int
+	polls    int
+	deletes  int
+	prefer   []string
+	location string
+	pending  int
+}
+
+func newAsyncServer(t *testing.T, location string, pending int) *asyncServer {
+	t.Helper()
+	s := &asyncServer{location: location, pending: pending}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.prefer = append(s.prefer, r.Header.Get(PreferHeader))
+		switch r.Method {
+		case http.MethodPost:
+			s.posts++
+			w.Header().Set("Location", s.location)
+			w.Header().Set(ExecutionIDHeader, "job-1")
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodDelete:
+			s.deletes++
+		case http.MethodGet:
+			if r.URL.Path != "/jobs/1" {
+				http.NotFound(w, r)
+				return
+			}
+			s.polls++
+			if s.polls <= s.pending {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(remote.RemoteResponse{Result: &remote.ExecuteResultPayload{Value: "done", Stdout: "out"}})
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestClientExecuteAsyncPolls(t *testing.T) {
+	srv := newAsyncServer(t, "/jobs/1", 2)
+	client, err := NewClient(Config{Endpoint: srv.URL, AuthToken: "token", Async: true, PollInterval: time.Millisecond, PollWait: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	resp, err := client.Execute(context.Background(), remote.RemoteRequest{})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Value != "done" || resp.Result.Stdout != "out" {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.posts != 1 || srv.polls != 3 {
+		t.Fatalf("posts = %d, polls = %d; want 1, 3", srv.posts, srv.polls)
+	}
+	if srv.prefer[0] != "respond-async" || srv.prefer[1] != "wait=5" {
+		t.Fatalf("Prefer headers = %q", srv.prefer)
+	}
+}
+
+func TestClientExecuteAsyncFallsBackToSyncResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(remote.RemoteResponse{Result: &remote.ExecuteResultPayload{Value: "sync"}})
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{Endpoint: srv.URL, Async: true})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	resp, err := client.Execute(context.Background(), remote.RemoteRequest{})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Value != "sync" {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+}
+
+func TestClientExecuteAsyncRejectsForeignStatusURL(t *testing.T) {
+	srv := newAsyncServer(t, "https://elsewhere.example/jobs/1", 0)
+	client, err := NewClient(Config{Endpoint: srv.URL, AuthToken: "token", Async: true})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	if _, err := client.Execute(context.Background(), remote.RemoteRequest{}); err == nil {
+		t.Fatal("expected error")
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.posts != 1 || srv.polls != 0 {
+		t.Fatalf("posts = %d, polls = %d; want 1, 0", srv.posts, srv.polls)
+	}
+}
+
+func TestClientExecuteAsyncCancels(t *testing.T) {
+	srv := newAsyncServer(t, "/jobs/1", 1<<30)
+	client, err := NewClient(Config{Endpoint: srv.URL, Async: true, PollInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.Execute(ctx, remote.RemoteRequest{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.deletes != 1 {
+		t.Fatalf("deletes = %d, want 1", srv.deletes)
+	}
+}
@@ -0,0 +1,286 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonwraymond/toolexec-integrations/internal/compression"
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// ErrAuthTokenUnavailable is returned when the TokenSource fails. The
+// request is not sent, so it is neither retried nor counted against the
+// circuit breaker or endpoint health.
+var ErrAuthTokenUnavailable = fmt.Errorf("%w: auth token unavailable", remote.ErrRemoteExecutionFailed)
+
+// TokenSource supplies bearer tokens for requests to the remote runtime.
+//
+// Contract:
+// - Concurrency: implementations must be safe for concurrent use.
+// - Token returns the token to send; an empty token sends no Authorization
+// header.
+// - Invalidate is called with a token the server rejected with 401. The next
+// Token call should return a different token if one can be obtained.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+	Invalidate(token string)
+}
+
+// StaticTokenSource returns a TokenSource that always returns token.
+func StaticTokenSource(token string) TokenSource {
+	return staticTokenSource(token)
+}
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token(context.Context) (string, error) { return string(s), nil }
+
+func (staticTokenSource) Invalidate(string) {}
+
+// FileTokenSource returns a TokenSource that reads the token from path,
+// such as a projected service account token or a mounted secret. The file
+// is re-read when its modification time or size changes, and after the
+// server rejects the token. Surrounding whitespace is trimmed.
+func FileTokenSource(path string) TokenSource {
+	return &fileTokenSource{path: path}
+}
+
+type fileTokenSource struct {
+	path string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+func (s *fileTokenSource) Token(context.Context) (string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.token, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", s.path)
+	}
+	s.token, s.modTime, s.size = token, info.ModTime(), info.Size()
+	return token, nil
+}
+
+func (s *fileTokenSource) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// ClientCredentialsConfig configures an OAuth2 client-credentials token
+// source (RFC 6749 section 4.4).
+type ClientCredentialsConfig struct {
+	// TokenURL is the authorization server's token endpoint.
+	TokenURL string
+
+	// ClientID and ClientSecret authenticate the client using HTTP Basic
+	// authentication.
+	ClientID     string
+	ClientSecret string
+
+	// Scopes are requested as a space-separated scope parameter.
+	Scopes []string
+
+	// EndpointParams are extra form parameters, such as audience.
+	EndpointParams url.Values
+
+	// ExpiryDelta refreshes tokens this long before they expire.
+	// Default: 30s
+	ExpiryDelta time.Duration
+
+	// HTTPClient overrides the default HTTP client.
+	HTTPClient *http.Client
+
+	// Timeout sets request timeout if HTTPClient is not provided.
+	Timeout time.Duration
+}
+
+// ClientCredentialsTokenSource returns a TokenSource that fetches tokens
+// from an OAuth2 token endpoint and caches them until shortly before they
+// expire. Tokens without expires_in are cached until invalidated.
+func ClientCredentialsTokenSource(cfg ClientCredentialsConfig) TokenSource {
+	client := cfg.HTTPClient
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout == 0 {
+			timeout = 30 * time.Second
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	delta := cfg.ExpiryDelta
+	if delta <= 0 {
+		delta = 30 * time.Second
+	}
+	return &clientCredentialsTokenSource{cfg: cfg, client: client, delta: delta}
+}
+
+type clientCredentialsTokenSource struct {
+	cfg    ClientCredentialsConfig
+	client *http.Client
+	delta  time.Duration
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (s *clientCredentialsTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && (s.expires.IsZero() || time.Now().Before(s.expires)) {
+		return s.token, nil
+	}
+	token, expiresIn, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	s.expires = time.Time{}
+	if expiresIn > 0 {
+		s.expires = time.Now().Add(expiresIn - s.delta)
+	}
+	return token, nil
+}
+
+func (s *clientCredentialsTokenSource) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+func (s *clientCredentialsTokenSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.cfg.Scopes, " "))
+	}
+	for key, values := range s.cfg.EndpointParams {
+		form[key] = values
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(s.cfg.ClientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("token request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, fmt.Errorf("token response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", 0, fmt.Errorf("token endpoint status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var payload struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", 0, fmt.Errorf("token response: %w", err)
+	}
+	if payload.AccessToken == "" {
+		return "", 0, errors.New("token response has no access_token")
+	}
+	if payload.TokenType != "" && !strings.EqualFold(payload.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token type %q", payload.TokenType)
+	}
+	return payload.AccessToken, time.Duration(payload.ExpiresIn) * time.Second, nil
+}
+
+// do authorizes and sends req, whose body is payload. If the server answers
+// 401, the rejected token is invalidated and the request is re-sent once
+// with a fresh token. gzip and zstd response bodies are decoded. Transport
+// errors wrap remote.ErrConnectionFailed, TokenSource errors wrap
+// ErrAuthTokenUnavailable, and signing errors wrap
+// remote.ErrRemoteExecutionFailed.
+func (c *Client) do(req *http.Request, payload []byte) (*http.Response, error) {
+	token, err := c.token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	if err := c.authorize(req, payload, token); err != nil {
+		return nil, err
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", compression.AcceptEncoding)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", remote.ErrConnectionFailed, err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized || token == "" {
+		return decodeResponse(resp)
+	}
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+	c.tokens.Invalidate(token)
+	if c.logger != nil {
+		c.logger.Warn("remote token rejected, refreshing", "endpoint", req.URL.Redacted())
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, fmt.Errorf("%w: %v", remote.ErrConnectionFailed, err)
+		}
+	}
+	if token, err = c.token(req.Context()); err != nil {
+		return nil, err
+	}
+	if err := c.authorize(retry, payload, token); err != nil {
+		return nil, err
+	}
+	resp, err = c.httpClient.Do(retry)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", remote.ErrConnectionFailed, err)
+	}
+	return decodeResponse(resp)
+}
+
+func (c *Client) token(ctx context.Context) (string, error) {
+	if c.tokens == nil {
+		return "", nil
+	}
+	token, err := c.tokens.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrAuthTokenUnavailable, err)
+	}
+	return token, nil
+}
@@ -0,0 +1,165 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+func TestClientCredentialsRefreshOn401(t *testing.T) {
+	var issued atomic.Int32
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "run exec" {
+			t.Errorf("unexpected token request: %s %s %v", id, secret, r.Form)
+		}
+		n := issued.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"tok-%d","token_type":"Bearer","expires_in":3600}`, n)
+	}))
+	defer tokenSrv.Close()
+
+	var rejected atomic.Int32
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok-2" {
+			rejected.Add(1)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(remote.RemoteResponse{Result: &remote.ExecuteResultPayload{Stdout: "ok"}})
+	}))
+	defer apiSrv.Close()
+
+	client, err := NewClient(Config{
+		Endpoint: apiSrv.URL,
+		TokenSource: ClientCredentialsTokenSource(ClientCredentialsConfig{
+			TokenURL:     tokenSrv.URL,
+			ClientID:     "client",
+			ClientSecret: "s3cret",
+			Scopes:       []string{"run", "exec"},
+		}),
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	for range 2 {
+		resp, err := client.Execute(context.Background(), remote.RemoteRequest{})
+		if err != nil {
+			t.Fatalf("Execute error: %v", err)
+		}
+		if resp.Result == nil || resp.Result.Stdout != "ok" {
+			t.Fatalf("unexpected result: %#v", resp.Result)
+		}
+	}
+	if issued.Load() != 2 || rejected.Load() != 1 {
+		t.Fatalf("tokens issued = %d, rejected = %d", issued.Load(), rejected.Load())
+	}
+}
+
+func TestClientCredentialsExpiry(t *testing.T) {
+	var issued atomic.Int32
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := issued.Add(1)
+		_, _ = fmt.Fprintf(w, `{"access_token":"tok-%d","expires_in":1}`, n)
+	}))
+	defer tokenSrv.Close()
+
+	source := ClientCredentialsTokenSource(ClientCredentialsConfig{TokenURL: tokenSrv.URL, ExpiryDelta: time.Second})
+	for want := 1; want <= 2; want++ {
+		token, err := source.Token(context.Background())
+		if err != nil {
+			t.Fatalf("Token error: %v", err)
+		}
+		if token != fmt.Sprintf("tok-%d", want) {
+			t.Fatalf("token = %q, want tok-%d", token, want)
+		}
+	}
+}
+
+func TestFileTokenSourceRereadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first\n"), 0o600); err != nil {
+		t.Fatalf("write token: %v", err)
+	}
+	source := FileTokenSource(path)
+	if token, err := source.Token(context.Background()); err != nil || token != "first" {
+		t.Fatalf("Token = %q, %v", token, err)
+	}
+
+	if err := os.WriteFile(path, []byte("second"), 0o600); err != nil {
+		t.Fatalf("write token: %v", err)
+	}
+	future := time.Now().Add(time.Second)
+	_ = os.Chtimes(path, future, future)
+	if token, err := source.Token(context.Background()); err != nil || token != "second" {
+		t.Fatalf("Token after rotation = %q, %v", token, err)
+	}
+}
+
+type failingTokenSource struct{ calls atomic.Int32 }
+
+func (s *failingTokenSource) Token(context.Context) (string, error) {
+	s.calls.Add(1)
+	return "", errors.New("token endpoint down")
+}
+
+func (s *failingTokenSource) Invalidate(string) {}
+
+func TestClientTokenSourceFailureIsNotRetried(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { hits.Add(1) }))
+	defer srv.Close()
+
+	tokens := &failingTokenSource{}
+	client, err := NewClient(Config{
+		Endpoint:       srv.URL,
+		TokenSource:    tokens,
+		MaxRetries:     3,
+		CircuitBreaker: &CircuitBreakerConfig{ConsecutiveFailures: 1},
+		HealthCheck:    &HealthCheckConfig{FailureThreshold: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	defer client.Close()
+
+	for i := 1; i <= 2; i++ {
+		_, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "x"}})
+		if !errors.Is(err, ErrAuthTokenUnavailable) || IsRetryable(err) {
+			t.Fatalf("expected non-retryable ErrAuthTokenUnavailable, got %v", err)
+		}
+		if got := tokens.calls.Load(); got != int32(i) {
+			t.Fatalf("token calls = %d, want %d", got, i)
+		}
+	}
+	if hits.Load() != 0 {
+		t.Fatalf("server hits = %d, want 0", hits.Load())
+	}
+	if state := client.CircuitState(); state != CircuitClosed {
+		t.Fatalf("circuit state = %v, want closed", state)
+	}
+	client.breaker.mu.Lock()
+	requests := client.breaker.requests
+	client.breaker.mu.Unlock()
+	if requests != 0 {
+		t.Fatalf("breaker requests = %d, want 0", requests)
+	}
+	client.pool.mu.Lock()
+	defer client.pool.mu.Unlock()
+	for _, e := range client.pool.endpoints {
+		if e.failures != 0 || !e.ejectedUntil.IsZero() || e.outstanding.Load() != 0 {
+			t.Fatalf("endpoint %s touched: failures %d, ejected until %v", e.url, e.failures, e.ejectedUntil)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package remotehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// LoadBalancing selects how requests are spread across endpoints.
+type LoadBalancing string
+
+const (
+	// RoundRobin sends requests to endpoints in turn.
+	RoundRobin LoadBalancing = "round_robin"
+	// LeastOutstanding sends each request to the endpoint with the fewest
+	// requests in flight.
+	LeastOutstanding LoadBalancing = "least_outstanding"
+	// Weighted sends requests in proportion to EndpointConfig.Weight.
+	Weighted LoadBalancing = "weighted"
+)
+
+// EndpointConfig is one runtime server in Config.Endpoints.
+type EndpointConfig struct {
+	// URL is the URL of the remote runtime service.
+	URL string
+
+	// Weight is the share of requests for Weighted balancing.
+	// Default: 1
+	Weight int
+
+	// Priority groups endpoints: requests go to the healthy endpoints with
+	// the lowest priority, and higher values are only used for failover.
+	// Default: 0
+	Priority int
+}
+
+// SRVConfig discovers endpoints from DNS SRV records. Targets with the
+// lowest SRV priority are preferred and SRV weights feed Weighted
+// balancing.
+type SRVConfig struct {
+	// Service, Proto and Name are passed to net.Resolver.LookupSRV, as in
+	// _Service._Proto.Name. Service and Proto may be empty to look up Name
+	// directly.
+	Service string
+	Proto   string
+	Name    string
+
+	// Scheme and Path complete each target into an endpoint URL.
+	// Default: "https" and ""
+	Scheme string
+	Path   string
+
+	// RefreshInterval is how long a lookup is used before it is repeated.
+	// If a refresh fails, the previous endpoints are kept.
+	// Default: 30s
+	RefreshInterval time.Duration
+
+	// Resolver overrides net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+// HealthCheckConfig configures endpoint health checking.
+type HealthCheckConfig struct {
+	// FailureThreshold ejects an endpoint after this many consecutive
+	// requests failed because it was unreachable or answered 5xx or 429.
+	// Default: 3
+	FailureThreshold int
+
+	// EjectFor is how long a passively ejected endpoint is skipped.
+	// Default: 30s
+	EjectFor time.Duration
+
+	// Interval enables active checks: every Interval, each endpoint gets a
+	// GET for Path, and endpoints that fail to answer 2xx are skipped until
+	// a later check succeeds. Active checks run until Client.Close.
+	// Default: 0 (passive checks only)
+	Interval time.Duration
+
+	// Path is requested on each endpoint's host by active checks. Checks
+	// are not authenticated.
+	// Default: "/healthz"
+	Path string
+
+	// Timeout bounds each active check.
+	// Default: 5s
+	Timeout time.Duration
+}
+
+// errNoEndpoints is returned when SRV discovery yields no endpoints.
+var errNoEndpoints = fmt.Errorf("%w: no endpoints available", remote.ErrRemoteNotAvailable)
+
+type endpoint struct {
+	url      *url.URL
+	weight   int
+	priority int
+
+	outstanding atomic.Int64
+
+	// Guarded by endpointPool.mu.
+	failures     int
+	ejectedUntil time.Time
+	activeDown   bool
+	current      int // smooth weighted round-robin state
+}
+
+// endpointPool picks the endpoint for each request and tracks health.
+type endpointPool struct {
+	balancing        LoadBalancing
+	failureThreshold int
+	ejectFor         time.Duration
+	logger           remote.Logger
+	now              func() time.Time
+
+	srv       *SRVConfig
+	lookupSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	resolved  time.Time
+	next      int
+
+	last atomic.Pointer[endpoint]
+}
+
+func newEndpointPool(cfg Config) (*endpointPool, error) {
+	p := &endpointPool{
+		balancing:        cfg.LoadBalancing,
+		failureThreshold: 3,
+		ejectFor:         30 * time.Second,
+		logger:           cfg.Logger,
+		now:              time.Now,
+		srv:              cfg.SRV,
+	}
+	if p.balancing == "" {
+		p.balancing = RoundRobin
+	}
+	switch p.balancing {
+	case RoundRobin, LeastOutstanding, Weighted:
+	default:
+		return nil, fmt.Errorf("unknown load balancing %q", p.balancing)
+	}
+	if hc := cfg.HealthCheck; hc != nil {
+		if hc.FailureThreshold > 0 {
+			p.failureThreshold = hc.FailureThreshold
+		}
+		if hc.EjectFor > 0 {
+			p.ejectFor = hc.EjectFor
+		}
+	}
+
+	configs := cfg.Endpoints
+	if cfg.Endpoint != "" {
+		configs = append([]EndpointConfig{{URL: cfg.Endpoint}}, configs...)
+	}
+	for _, ec := range configs {
+		e, err := newEndpoint(ec.URL, ec.Weight, ec.Priority)
+		if err != nil {
+			return nil, err
+		}
+		p.endpoints = append(p.endpoints, e)
+	}
+
+	if p.srv != nil {
+		if p.srv.Name == "" {
+			return nil, errors.New("srv name is required")
+		}
+		resolver := p.srv.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		p.lookupSRV = resolver.LookupSRV
+	} else if len(p.endpoints) == 0 {
+		return nil, remote.ErrRemoteNotAvailable
+	}
+	if len(p.endpoints) > 0 {
+		p.last.Store(p.endpoints[0])
+	}
+	return p, nil
+}
+
+func newEndpoint(rawURL string, weight, priority int) (*endpoint, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	return &endpoint{url: parsed, weight: weight, priority: priority}, nil
+}
+
+// lastUsed returns the endpoint of the most recent request.
+func (p *endpointPool) lastUsed() *url.URL {
+	if e := p.last.Load(); e != nil {
+		return e.url
+	}
+	return nil
+}
+
+// pick chooses the endpoint for the next request, preferring endpoints not
+// in tried. The caller must pass it to release.
+func (p *endpointPool) pick(ctx context.Context, tried []*endpoint) (*endpoint, error) {
+	if err := p.refreshSRV(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.endpoints) == 0 {
+		return nil, errNoEndpoints
+	}
+	now := p.now()
+	candidates := p.filter(func(e *endpoint) bool {
+		return !slices.Contains(tried, e) && !e.activeDown && !now.Before(e.ejectedUntil)
+	})
+	if len(candidates) == 0 {
+		// Every untried endpoint is unhealthy; retrying a tried but
+		// healthy one beats giving up.
+		candidates = p.filter(func(e *endpoint) bool {
+			return !e.activeDown && !now.Before(e.ejectedUntil)
+		})
+	}
+	if len(candidates) == 0 {
+		// Health information is all negative; it may be stale, so spread
+		// the load rather than failing every request.
+		candidates = p.filter(func(*endpoint) bool { return true })
+	}
+
+	best := candidates[0].priority
+	for _, e := range candidates {
+		best = min(best, e.priority)
+	}
+	candidates = slices.DeleteFunc(candidates, func(e *endpoint) bool { return e.priority != best })
+
+	var chosen *endpoint
+	switch p.balancing {
+	case LeastOutstanding:
+		start := p.next % len(candidates)
+		p.next++
+		for i := range candidates {
+			e := candidates[(start+i)%len(candidates)]
+			if chosen == nil || e.outstanding.Load() < chosen.outstanding.Load() {
+				chosen = e
+			}
+		}
+	case Weighted:
+		total := 0
+		for _, e := range candidates {
+			e.current += e.weight
+			total += e.weight
+			if chosen == nil || e.current > chosen.current {
+				chosen = e
+			}
+		}
+		chosen.current -= total
+	default:
+		chosen = candidates[p.next%len(candidates)]
+		p.next++
+	}
+
+	chosen.outstanding.Add(1)
+	p.last.Store(chosen)
+	return chosen, nil
+}
+
+// hasUntried reports whether some endpoint is not in tried.
+func (p *endpointPool) hasUntried(tried []*endpoint) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range p.endpoints {
+		if !slices.Contains(tried, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the endpoints matching keep. Callers hold p.mu.
+func (p *endpointPool) filter(keep func(*endpoint) bool) []*endpoint {
+	var out []*endpoint
+	for _, e := range p.endpoints {
+		if keep(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// release ends a request started with pick and records its outcome for
+// passive health checking. err is ignored if the request was unsent or
+// the caller canceled.
+func (p *endpointPool) release(e *endpoint, err error, unsent bool) {
+	e.outstanding.Add(-1)
+	if unsent {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !serverUnavailable(err) {
+		e.failures = 0
+		return
+	}
+	e.failures++
+	if e.failures >= p.failureThreshold {
+		e.failures = 0
+		e.ejectedUntil = p.now().Add(p.ejectFor)
+		if p.logger != nil {
+			p.logger.Warn("remote endpoint ejected", "endpoint", e.url.Redacted(), "for", p.ejectFor, "error", err)
+		}
+	}
+}
+
+// refreshSRV repeats the SRV lookup once RefreshInterval has passed.
+func (p *endpointPool) refreshSRV(ctx context.Context) error {
+	if p.srv == nil {
+		return nil
+	}
+	interval := p.srv.RefreshInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	p.mu.Lock()
+	fresh := !p.resolved.IsZero() && p.now().Sub(p.resolved) < interval
+	p.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	_, records, err := p.lookupSRV(ctx, p.srv.Service, p.srv.Proto, p.srv.Name)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resolved = p.now()
+	if err != nil || len(records) == 0 {
+		if err == nil {
+			err = errors.New("no records")
+		}
+		if p.logger != nil {
+			p.logger.Warn("remote endpoint discovery failed", "name", p.srv.Name, "error", err)
+		}
+		if len(p.endpoints) == 0 {
+			return fmt.Errorf("%w: srv lookup %s: %v", remote.ErrRemoteNotAvailable, p.srv.Name, err)
+		}
+		return nil
+	}
+
+	scheme := p.srv.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	existing := map[string]*endpoint{}
+	for _, e := range p.endpoints {
+		existing[e.url.String()] = e
+	}
+	endpoints := make([]*endpoint, 0, len(records))
+	for _, record := range records {
+		host := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		u := &url.URL{Scheme: scheme, Host: host, Path: p.srv.Path}
+		if e, ok := existing[u.String()]; ok {
+			// Keep health and load state across refreshes.
+			e.weight, e.priority = max(int(record.Weight), 1), int(record.Priority)
+			endpoints = append(endpoints, e)
+			continue
+		}
+		endpoints = append(endpoints, &endpoint{url: u, weight: max(int(record.Weight), 1), priority: int(record.Priority)})
+	}
+	p.endpoints = endpoints
+	return nil
+}
+
+// healthChecker runs active health checks until stopped.
+type healthChecker struct {
+	client   *http.Client
+	pool     *endpointPool
+	interval time.Duration
+	timeout  time.Duration
+	path     string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startHealthChecker(pool *endpointPool, client *http.Client, cfg *HealthCheckConfig) *healthChecker {
+	if cfg == nil || cfg.Interval <= 0 {
+		return nil
+	}
+	h := &healthChecker{
+		client:   client,
+		pool:     pool,
+		interval: cfg.Interval,
+		timeout:  cfg.Timeout,
+		path:     cfg.Path,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if h.timeout <= 0 {
+		h.timeout = 5 * time.Second
+	}
+	if h.path == "" {
+		h.path = "/healthz"
+	}
+	go h.run()
+	return h
+}
+
+func (h *healthChecker) run() {
+	defer close(h.done)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.checkAll()
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *healthChecker) close() {
+	close(h.stop)
+	<-h.done
+}
+
+func (h *healthChecker) checkAll() {
+	h.pool.mu.Lock()
+	endpoints := slices.Clone(h.pool.endpoints)
+	h.pool.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, e := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := h.check(e)
+			h.pool.mu.Lock()
+			defer h.pool.mu.Unlock()
+			if down := err != nil; down != e.activeDown {
+				e.activeDown = down
+				if h.pool.logger != nil {
+					h.pool.logger.Info("remote endpoint health changed", "endpoint", e.url.Redacted(), "healthy", !down, "error", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (h *healthChecker) check(e *endpoint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	target := *e.url
+	target.Path, target.RawPath, target.RawQuery = h.path, "", ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
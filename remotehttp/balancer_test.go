@@ -0,0 +1,213 @@
+package remotehttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// newCountingServer answers every execute request with a fixed result and
+// every health check with health.
+func newCountingServer(t *testing.T, hits *atomic.Int32, health int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(health)
+			return
+		}
+		hits.Add(1)
+		_, _ = w.Write([]byte(`{"result":{"value":"ok"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientLoadBalancing(t *testing.T) {
+	for _, tc := range []struct {
+		balancing LoadBalancing
+		weights   []int
+		want      []int32
+	}{
+		{RoundRobin, []int{3, 1, 1}, []int32{3, 3, 3}},
+		{Weighted, []int{3, 1, 2}, []int32{6, 2, 4}},
+		{LeastOutstanding, []int{1, 1, 1}, []int32{4, 4, 4}},
+	} {
+		hits := make([]atomic.Int32, len(tc.weights))
+		var endpoints []EndpointConfig
+		for i, weight := range tc.weights {
+			srv := newCountingServer(t, &hits[i], http.StatusOK)
+			endpoints = append(endpoints, EndpointConfig{URL: srv.URL, Weight: weight})
+		}
+		client, err := NewClient(Config{Endpoints: endpoints, LoadBalancing: tc.balancing})
+		if err != nil {
+			t.Fatalf("NewClient error: %v", err)
+		}
+
+		var total int32
+		for _, n := range tc.want {
+			total += n
+		}
+		for range total {
+			if _, err := client.Execute(context.Background(), remote.RemoteRequest{}); err != nil {
+				t.Fatalf("Execute error: %v", err)
+			}
+		}
+		for i := range hits {
+			if hits[i].Load() != tc.want[i] {
+				t.Fatalf("%s: endpoint %d hits = %d, want %d", tc.balancing, i, hits[i].Load(), tc.want[i])
+			}
+		}
+	}
+}
+
+func TestEndpointPoolLeastOutstanding(t *testing.T) {
+	pool, err := newEndpointPool(Config{
+		Endpoints:     []EndpointConfig{{URL: "http://a"}, {URL: "http://b"}},
+		LoadBalancing: LeastOutstanding,
+	})
+	if err != nil {
+		t.Fatalf("newEndpointPool error: %v", err)
+	}
+	first, _ := pool.pick(context.Background(), nil)
+	second, _ := pool.pick(context.Background(), nil)
+	if first == second {
+		t.Fatalf("busy endpoint %s picked twice", first.url)
+	}
+	pool.release(first, nil, false)
+	third, _ := pool.pick(context.Background(), nil)
+	if third != first {
+		t.Fatalf("picked %s, want idle %s", third.url, first.url)
+	}
+}
+
+func TestClientFailsOverAndEjects(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	var hits atomic.Int32
+	live := newCountingServer(t, &hits, http.StatusOK)
+
+	client, err := NewClient(Config{
+		Endpoints:   []EndpointConfig{{URL: dead.URL}, {URL: live.URL}},
+		RetryPolicy: ExponentialBackoff{BaseDelay: time.Hour},
+		HealthCheck: &HealthCheckConfig{FailureThreshold: 1, EjectFor: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	// The first request fails over from the dead endpoint without waiting
+	// for the hour-long backoff; afterwards the dead endpoint is ejected.
+	for range 3 {
+		if _, err := client.Execute(context.Background(), remote.RemoteRequest{}); err != nil {
+			t.Fatalf("Execute error: %v", err)
+		}
+		if client.Endpoint() != live.URL {
+			t.Fatalf("Endpoint() = %s, want %s", client.Endpoint(), live.URL)
+		}
+	}
+	if hits.Load() != 3 {
+		t.Fatalf("hits = %d, want 3", hits.Load())
+	}
+}
+
+func TestClientResumeStaysOnEndpoint(t *testing.T) {
+	first := newDisconnectingServer(t, "exec-1", resumeTestEvents, 2)
+	second := newDisconnectingServer(t, "exec-1", resumeTestEvents, 2)
+	client, err := NewClient(Config{
+		Endpoints:   []EndpointConfig{{URL: first.URL}, {URL: second.URL}},
+		RetryPolicy: ExponentialBackoff{BaseDelay: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	if _, err := client.ExecuteStream(context.Background(), remote.RemoteRequest{}, nil); err != nil {
+		t.Fatalf("ExecuteStream error: %v", err)
+	}
+	if first.posts != 1 || first.resumes != 1 || second.posts != 0 || second.resumes != 0 {
+		t.Fatalf("first: posts=%d resumes=%d; second: posts=%d resumes=%d", first.posts, first.resumes, second.posts, second.resumes)
+	}
+}
+
+func TestClientSRVDiscovery(t *testing.T) {
+	var preferredHits, backupHits atomic.Int32
+	preferred := newCountingServer(t, &preferredHits, http.StatusOK)
+	backup := newCountingServer(t, &backupHits, http.StatusOK)
+
+	client, err := NewClient(Config{SRV: &SRVConfig{Service: "toolruntime", Proto: "tcp", Name: "example.test", Scheme: "http"}})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	var lookups atomic.Int32
+	client.pool.lookupSRV = func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		lookups.Add(1)
+		if service != "toolruntime" || proto != "tcp" || name != "example.test" {
+			t.Errorf("lookup %s %s %s", service, proto, name)
+		}
+		return "", []*net.SRV{srvRecord(t, backup.URL, 10), srvRecord(t, preferred.URL, 0)}, nil
+	}
+
+	for range 3 {
+		if _, err := client.Execute(context.Background(), remote.RemoteRequest{}); err != nil {
+			t.Fatalf("Execute error: %v", err)
+		}
+	}
+	if preferredHits.Load() != 3 || backupHits.Load() != 0 || lookups.Load() != 1 {
+		t.Fatalf("preferred = %d, backup = %d, lookups = %d", preferredHits.Load(), backupHits.Load(), lookups.Load())
+	}
+}
+
+func srvRecord(t *testing.T, rawURL string, priority uint16) *net.SRV {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("parse %s: %v", rawURL, err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	return &net.SRV{Target: u.Hostname() + ".", Port: uint16(port), Priority: priority, Weight: 1}
+}
+
+func TestClientActiveHealthChecks(t *testing.T) {
+	var sickHits, healthyHits atomic.Int32
+	sick := newCountingServer(t, &sickHits, http.StatusServiceUnavailable)
+	healthy := newCountingServer(t, &healthyHits, http.StatusOK)
+
+	client, err := NewClient(Config{
+		Endpoints:   []EndpointConfig{{URL: sick.URL}, {URL: healthy.URL}},
+		HealthCheck: &HealthCheckConfig{Interval: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.pool.mu.Lock()
+		down := client.pool.endpoints[0].activeDown
+		client.pool.mu.Unlock()
+		if down {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("sick endpoint not marked down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for range 4 {
+		if _, err := client.Execute(context.Background(), remote.RemoteRequest{}); err != nil {
+			t.Fatalf("Execute error: %v", err)
+		}
+	}
+	if sickHits.Load() != 0 || healthyHits.Load() != 4 {
+		t.Fatalf("sick = %d, healthy = %d", sickHits.Load(), healthyHits.Load())
+	}
+}
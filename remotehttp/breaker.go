@@ -0,0 +1,253 @@
+package remotehttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// ErrCircuitOpen is returned without contacting the server while the
+// circuit breaker is open. It wraps remote.ErrRemoteNotAvailable.
+var ErrCircuitOpen = fmt.Errorf("%w: circuit breaker open", remote.ErrRemoteNotAvailable)
+
+// CircuitBreakerConfig configures the optional circuit breaker around
+// execute requests. A request fails, for the breaker, when the server
+// cannot be reached or answers 5xx or 429; execution errors reported by the
+// runtime and the caller's own cancellation do not count.
+type CircuitBreakerConfig struct {
+	// ConsecutiveFailures opens the circuit after this many failed requests
+	// in a row.
+	// Default: 5
+	ConsecutiveFailures int
+
+	// FailureRate opens the circuit when at least this fraction of the
+	// requests in the current Window failed, once MinRequests were made.
+	// Default: 0.5
+	FailureRate float64
+
+	// MinRequests is the number of requests in a Window before FailureRate
+	// applies.
+	// Default: 20
+	MinRequests int
+
+	// Window is the period over which FailureRate is computed.
+	// Default: 1m
+	Window time.Duration
+
+	// OpenTimeout is how long the circuit stays open before it lets probe
+	// requests through.
+	// Default: 30s
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of concurrent probe requests allowed
+	// while half-open. The circuit closes when one succeeds and opens again
+	// when one fails.
+	// Default: 1
+	HalfOpenProbes int
+}
+
+// CircuitState is the state of the circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails requests with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+type circuitBreaker struct {
+	consecutiveLimit int
+	failureRate      float64
+	minRequests      int
+	window           time.Duration
+	openTimeout      time.Duration
+	halfOpenProbes   int
+	logger           remote.Logger
+	now              func() time.Time
+
+	mu          sync.Mutex
+	state       CircuitState
+	consecutive int
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+}
+
+func newCircuitBreaker(cfg *CircuitBreakerConfig, logger remote.Logger) *circuitBreaker {
+	if cfg == nil {
+		return nil
+	}
+	b := &circuitBreaker{
+		consecutiveLimit: cfg.ConsecutiveFailures,
+		failureRate:      cfg.FailureRate,
+		minRequests:      cfg.MinRequests,
+		window:           cfg.Window,
+		openTimeout:      cfg.OpenTimeout,
+		halfOpenProbes:   cfg.HalfOpenProbes,
+		logger:           logger,
+		now:              time.Now,
+	}
+	if b.consecutiveLimit <= 0 {
+		b.consecutiveLimit = 5
+	}
+	if b.failureRate <= 0 || b.failureRate > 1 {
+		b.failureRate = 0.5
+	}
+	if b.minRequests <= 0 {
+		b.minRequests = 20
+	}
+	if b.window <= 0 {
+		b.window = time.Minute
+	}
+	if b.openTimeout <= 0 {
+		b.openTimeout = 30 * time.Second
+	}
+	if b.halfOpenProbes <= 0 {
+		b.halfOpenProbes = 1
+	}
+	return b
+}
+
+// allow reports whether a request may be sent. probe is set for requests
+// admitted while half-open; their outcome must be passed to done.
+func (b *circuitBreaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return false, ErrCircuitOpen
+		}
+		b.setState(CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if b.probes >= b.halfOpenProbes {
+			return false, ErrCircuitOpen
+		}
+		b.probes++
+		return true, nil
+	}
+	return false, nil
+}
+
+// done records the outcome of a request admitted by allow.
+func (b *circuitBreaker) done(probe bool, err error) {
+	failed := serverUnavailable(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probes--
+		if b.state != CircuitHalfOpen {
+			return
+		}
+		if failed {
+			b.open()
+		} else {
+			b.setState(CircuitClosed)
+		}
+		return
+	}
+	if b.state != CircuitClosed {
+		return
+	}
+
+	now := b.now()
+	if now.Sub(b.windowStart) >= b.window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	b.requests++
+	if !failed {
+		b.consecutive = 0
+		return
+	}
+	b.failures++
+	b.consecutive++
+	if b.consecutive >= b.consecutiveLimit ||
+		(b.requests >= b.minRequests && float64(b.failures) >= b.failureRate*float64(b.requests)) {
+		b.open()
+	}
+}
+
+// release returns a request admitted by allow without recording an
+// outcome.
+func (b *circuitBreaker) release(probe bool) {
+	if !probe {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probes--
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = b.now()
+	b.setState(CircuitOpen)
+}
+
+// setState switches state and resets the counters. Callers hold b.mu.
+func (b *circuitBreaker) setState(state CircuitState) {
+	if state == b.state {
+		return
+	}
+	from := b.state
+	b.state = state
+	b.consecutive, b.requests, b.failures = 0, 0, 0
+	b.windowStart = b.now()
+	if b.logger == nil {
+		return
+	}
+	if state == CircuitOpen {
+		b.logger.Warn("remote circuit breaker state change", "from", from.String(), "to", state.String(), "open_for", b.openTimeout)
+	} else {
+		b.logger.Info("remote circuit breaker state change", "from", from.String(), "to", state.String())
+	}
+}
+
+func (b *circuitBreaker) currentState() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// serverUnavailable reports whether err means the server is unavailable.
+func serverUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return IsRetryable(err)
+}
+
+// CircuitState returns the state of the circuit breaker, or CircuitClosed
+// if none is configured.
+func (c *Client) CircuitState() CircuitState {
+	if c.breaker == nil {
+		return CircuitClosed
+	}
+	return c.breaker.currentState()
+}
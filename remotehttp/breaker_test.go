@@ -0,0 +1,148 @@
+package remotehttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) record(msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if msg == "remote circuit breaker state change" {
+		for i := 0; i+1 < len(args); i += 2 {
+			if args[i] == "to" {
+				msg = args[i+1].(string)
+			}
+		}
+		l.messages = append(l.messages, msg)
+	}
+}
+
+func (l *recordingLogger) Info(msg string, args ...any)  { l.record(msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...any)  { l.record(msg, args) }
+func (l *recordingLogger) Error(msg string, args ...any) { l.record(msg, args) }
+
+// newFlakyServer fails requests with 503 while failing is set.
+func newFlakyServer(t *testing.T, failing *atomic.Bool, hits *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"result":{"value":"ok"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	var failing atomic.Bool
+	var hits atomic.Int32
+	failing.Store(true)
+	srv := newFlakyServer(t, &failing, &hits)
+	logger := &recordingLogger{}
+
+	client, err := NewClient(Config{
+		Endpoint:       srv.URL,
+		MaxRetries:     1,
+		RetryPolicy:    ExponentialBackoff{BaseDelay: time.Millisecond},
+		CircuitBreaker: &CircuitBreakerConfig{ConsecutiveFailures: 3, OpenTimeout: time.Minute},
+		Logger:         logger,
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	now := time.Now()
+	client.breaker.now = func() time.Time { return now }
+
+	for range 2 {
+		_, _ = client.Execute(context.Background(), remote.RemoteRequest{})
+	}
+	if hits.Load() != 3 || client.CircuitState() != CircuitOpen {
+		t.Fatalf("hits = %d, state = %s; want 3, open", hits.Load(), client.CircuitState())
+	}
+
+	_, err = client.Execute(context.Background(), remote.RemoteRequest{})
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, remote.ErrRemoteNotAvailable) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if hits.Load() != 3 {
+		t.Fatalf("open circuit sent a request")
+	}
+
+	// A failed probe opens the circuit again.
+	now = now.Add(time.Minute)
+	_, _ = client.Execute(context.Background(), remote.RemoteRequest{})
+	if hits.Load() != 4 || client.CircuitState() != CircuitOpen {
+		t.Fatalf("hits = %d, state = %s; want 4, open", hits.Load(), client.CircuitState())
+	}
+
+	now = now.Add(time.Minute)
+	failing.Store(false)
+	if _, err := client.Execute(context.Background(), remote.RemoteRequest{}); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if client.CircuitState() != CircuitClosed {
+		t.Fatalf("state = %s, want closed", client.CircuitState())
+	}
+
+	want := []string{"open", "half-open", "open", "half-open", "closed"}
+	if len(logger.messages) != len(want) {
+		t.Fatalf("state changes = %v, want %v", logger.messages, want)
+	}
+	for i := range want {
+		if logger.messages[i] != want[i] {
+			t.Fatalf("state changes = %v, want %v", logger.messages, want)
+		}
+	}
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerConfig{ConsecutiveFailures: 100, FailureRate: 0.5, MinRequests: 4}, nil)
+	failure := &StatusError{StatusCode: http.StatusBadGateway}
+	for i, err := range []error{nil, failure, nil, failure} {
+		if b.currentState() != CircuitClosed {
+			t.Fatalf("opened after %d requests", i)
+		}
+		probe, allowErr := b.allow()
+		if allowErr != nil {
+			t.Fatalf("allow error: %v", allowErr)
+		}
+		b.done(probe, err)
+	}
+	if b.currentState() != CircuitOpen {
+		t.Fatalf("state = %s, want open", b.currentState())
+	}
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	b := newCircuitBreaker(&CircuitBreakerConfig{ConsecutiveFailures: 1}, nil)
+	for _, err := range []error{
+		&StatusError{StatusCode: http.StatusBadRequest},
+		remote.ErrRemoteExecutionFailed,
+	} {
+		b.done(false, err)
+	}
+	if b.currentState() != CircuitClosed {
+		t.Fatalf("state = %s, want closed", b.currentState())
+	}
+	b.done(false, remote.ErrConnectionFailed)
+	if b.currentState() != CircuitOpen {
+		t.Fatalf("state = %s, want open", b.currentState())
+	}
+}
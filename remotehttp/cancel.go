@@ -0,0 +1,85 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// EventExecution announces the execution ID as the first event of a stream,
+// for servers that cannot set ExecutionIDHeader (for example behind proxies
+// that strip headers). Its data is {"execution_id": "<id>"}.
+//
+// Cancel contract: when the caller's context ends before a streamed or async
+// execution finished, the client issues
+//
+//	DELETE <endpoint>
+//	X-Toolruntime-Execution-Id: <execution id>
+//
+// (signed like the original request, with an empty body). The server stops
+// the execution and answers 2xx; 404 or 410 mean it already ended. The
+// call is best effort: it is bounded by Config.CancelTimeout and failures
+// are only logged. Calls canceled before the response headers arrive carry
+// no execution ID; servers should stop work when the connection closes.
+const EventExecution = "execution"
+
+// executionEvent is the payload of EventExecution.
+type executionEvent struct {
+	ExecutionID string `json:"execution_id"`
+}
+
+// cancelAbandoned asks the server to stop an execution the caller gave up
+// on. It does nothing unless ctx has ended before a terminal event.
+func (c *Client) cancelAbandoned(ctx context.Context, endpoint *url.URL, executionID string, done bool) {
+	if ctx.Err() == nil || done || executionID == "" {
+		return
+	}
+	cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.cancelTimeout)
+	defer cancel()
+	if err := c.cancelExecution(cancelCtx, endpoint, executionID); err != nil {
+		if c.logger != nil {
+			c.logger.Warn("remote execution cancel failed", "execution_id", executionID, "error", err)
+		}
+		return
+	}
+	if c.logger != nil {
+		c.logger.Info("remote execution canceled", "execution_id", executionID)
+	}
+}
+
+func (c *Client) cancelExecution(ctx context.Context, endpoint *url.URL, executionID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint.String(), nil)
+	if err != nil {
+		return fmt.Errorf("%w: build request: %v", remote.ErrConnectionFailed, err)
+	}
+	req.Header.Set(ExecutionIDHeader, executionID)
+	resp, err := c.do(req, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil
+	default:
+		return &StatusError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+}
+
+// recordExecution adopts the execution ID announced by an EventExecution.
+// The response header wins if both are present.
+func (s *streamState) recordExecution(data string) {
+	var event executionEvent
+	if err := json.Unmarshal([]byte(data), &event); err == nil && s.executionID == "" {
+		s.executionID = event.ExecutionID
+	}
+}
@@ -0,0 +1,105 @@
+package remotehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// newHangingServer streams one stdout event and then holds the stream open
+// until the test ends. DELETE requests are reported on canceled.
+func newHangingServer(t *testing.T, viaHeader bool) (*httptest.Server, <-chan *http.Request) {
+	t.Helper()
+	canceled := make(chan *http.Request, 1)
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			canceled <- r
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if viaHeader {
+			w.Header().Set(ExecutionIDHeader, "exec-1")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		if !viaHeader {
+			_, _ = fmt.Fprintf(w, "event: %s\ndata: {\"execution_id\":\"exec-1\"}\n\n", EventExecution)
+		}
+		_, _ = fmt.Fprintf(w, "event: stdout\ndata: working\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(stop)
+		srv.Close()
+	})
+	return srv, canceled
+}
+
+func TestClientCancelsRemoteExecution(t *testing.T) {
+	for _, viaHeader := range []bool{true, false} {
+		srv, canceled := newHangingServer(t, viaHeader)
+		client, err := NewClient(Config{Endpoint: srv.URL, AuthToken: "token"})
+		if err != nil {
+			t.Fatalf("NewClient error: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err = client.ExecuteStream(ctx, remote.RemoteRequest{}, func(event StreamEvent) {
+			if event.Name == EventStdout {
+				cancel()
+			}
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("viaHeader=%v: expected context.Canceled, got %v", viaHeader, err)
+		}
+
+		select {
+		case r := <-canceled:
+			if got := r.Header.Get(ExecutionIDHeader); got != "exec-1" {
+				t.Fatalf("cancel execution ID = %q", got)
+			}
+			if r.Header.Get(SignatureHeader) == "" {
+				t.Fatal("cancel request not signed")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("viaHeader=%v: no cancel request", viaHeader)
+		}
+	}
+}
+
+func TestClientDoesNotCancelFinishedExecution(t *testing.T) {
+	var deletes int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deletes++
+			return
+		}
+		w.Header().Set(ExecutionIDHeader, "exec-1")
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprintf(w, "event: result\ndata: {\"value\":1}\n\n")
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := client.ExecuteStream(ctx, remote.RemoteRequest{}, nil); err != nil {
+		t.Fatalf("ExecuteStream error: %v", err)
+	}
+	cancel()
+	if deletes != 0 {
+		t.Fatalf("deletes = %d, want 0", deletes)
+	}
+}
@@ -0,0 +1,192 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// ProtocolVersion is the version of the wire protocol this package speaks.
+// Servers advertising a different major version are refused when
+// Config.CheckCapabilities is set.
+const ProtocolVersion = "1.0"
+
+// WellKnownPath is where a runtime publishes its Capabilities document, on
+// the host of its endpoint. Requests are authenticated and signed like
+// execute requests.
+const WellKnownPath = "/.well-known/toolruntime"
+
+// ErrUnsupported is returned when Config.CheckCapabilities is set and the
+// runtime's capabilities rule out the request. It wraps
+// remote.ErrRemoteExecutionFailed.
+var ErrUnsupported = fmt.Errorf("%w: not supported by the remote runtime", remote.ErrRemoteExecutionFailed)
+
+// ErrCapabilitiesUnknown is returned by Client.Capabilities when the runtime
+// answers 404 at WellKnownPath. The runtime is reachable but predates
+// capability discovery, so what it supports is unknown. It wraps
+// remote.ErrRemoteExecutionFailed.
+var ErrCapabilitiesUnknown = fmt.Errorf("%w: runtime publishes no capabilities", remote.ErrRemoteExecutionFailed)
+
+// Capabilities describes what a remote runtime supports.
+type Capabilities struct {
+	// ProtocolVersion is the wire protocol version, as "major.minor".
+	ProtocolVersion string `json:"protocol_version"`
+
+	// Languages lists the accepted request languages. Empty means any.
+	Languages []string `json:"languages,omitempty"`
+
+	// MaxTimeoutMs is the longest accepted request timeout. Zero means no
+	// limit.
+	MaxTimeoutMs int64 `json:"max_timeout_ms,omitempty"`
+
+	// Compression lists the request Content-Encodings the runtime accepts,
+	// such as "gzip" and "zstd".
+	Compression []string `json:"compression,omitempty"`
+
+	// Streaming, ToolCalls, Resume, Async and Cancel report support for SSE
+	// responses, tool callbacks, resumable streams, async jobs and cancel
+	// requests.
+	Streaming bool `json:"streaming"`
+	ToolCalls bool `json:"tool_calls"`
+	Resume    bool `json:"resume"`
+	Async     bool `json:"async"`
+	Cancel    bool `json:"cancel"`
+}
+
+// capabilitiesCache holds the last fetched Capabilities. The runtimes
+// behind all endpoints are assumed to be the same.
+type capabilitiesCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	caps    Capabilities
+	err     error // ErrCapabilitiesUnknown if the runtime has no capabilities endpoint
+	known   bool
+	fetched time.Time
+}
+
+// Ping checks that the runtime answers the capabilities endpoint, and
+// refreshes the cached capabilities. A runtime that answers 404 there is
+// healthy but predates capability discovery, so the ping succeeds.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.refreshCapabilities(ctx)
+	if errors.Is(err, ErrCapabilitiesUnknown) {
+		return nil
+	}
+	return err
+}
+
+// Capabilities returns the runtime's capabilities, fetching them if the
+// cached copy is older than Config.CapabilitiesTTL. A runtime without the
+// capabilities endpoint yields ErrCapabilitiesUnknown, which means its
+// capabilities are unknown rather than that it is unreachable; that
+// answer is cached too.
+func (c *Client) Capabilities(ctx context.Context) (Capabilities, error) {
+	cache := &c.capabilities
+	cache.mu.Lock()
+	caps, err, fresh := cache.caps, cache.err, cache.known && time.Since(cache.fetched) < cache.ttl
+	cache.mu.Unlock()
+	if fresh {
+		return caps, err
+	}
+	return c.refreshCapabilities(ctx)
+}
+
+func (c *Client) refreshCapabilities(ctx context.Context) (Capabilities, error) {
+	caps, err := c.fetchCapabilities(ctx)
+	var statusErr *StatusError
+	if err != nil {
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+			return Capabilities{}, err
+		}
+		err = ErrCapabilitiesUnknown
+	}
+	cache := &c.capabilities
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.caps, cache.err, cache.known, cache.fetched = caps, err, true, time.Now()
+	return caps, err
+}
+
+func (c *Client) fetchCapabilities(ctx context.Context) (Capabilities, error) {
+	target, err := c.pool.pick(ctx, nil)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	caps, err := c.getCapabilities(ctx, target.url)
+	c.pool.release(target, err, ctx.Err() != nil || errors.Is(err, ErrAuthTokenUnavailable))
+	return caps, err
+}
+
+func (c *Client) getCapabilities(ctx context.Context, endpoint *url.URL) (Capabilities, error) {
+	wellKnown := &url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host, Path: WellKnownPath}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown.String(), nil)
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("%w: build request: %v", remote.ErrConnectionFailed, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.do(req, nil)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return Capabilities{}, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(body)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	var caps Capabilities
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&caps); err != nil {
+		return Capabilities{}, fmt.Errorf("%w: decode capabilities: %v", remote.ErrRemoteExecutionFailed, err)
+	}
+	return caps, nil
+}
+
+// checkCapabilities refuses payload if the runtime cannot satisfy it. A
+// runtime without a capabilities endpoint, or one that cannot be reached
+// right now, is given the benefit of the doubt.
+func (c *Client) checkCapabilities(ctx context.Context, payload remote.RemoteRequest) error {
+	if !c.checkCaps {
+		return nil
+	}
+	caps, err := c.Capabilities(ctx)
+	if err != nil {
+		if c.logger != nil {
+			c.logger.Warn("remote capabilities unavailable", "error", err)
+		}
+		return nil
+	}
+
+	req := payload.Request
+	switch {
+	case majorVersion(caps.ProtocolVersion) != majorVersion(ProtocolVersion):
+		return fmt.Errorf("%w: protocol version %q", ErrUnsupported, caps.ProtocolVersion)
+	case payload.Stream && !caps.Streaming:
+		return fmt.Errorf("%w: streaming", ErrUnsupported)
+	case len(c.tools) > 0 && !caps.ToolCalls:
+		return fmt.Errorf("%w: tool calls", ErrUnsupported)
+	case req.Language != "" && len(caps.Languages) > 0 && !slices.Contains(caps.Languages, req.Language):
+		return fmt.Errorf("%w: language %q (supported: %s)", ErrUnsupported, req.Language, strings.Join(caps.Languages, ", "))
+	case req.TimeoutMillis > 0 && caps.MaxTimeoutMs > 0 && req.TimeoutMillis > caps.MaxTimeoutMs:
+		return fmt.Errorf("%w: timeout %dms exceeds maximum %dms", ErrUnsupported, req.TimeoutMillis, caps.MaxTimeoutMs)
+	}
+	return nil
+}
+
+func majorVersion(version string) string {
+	major, _, _ := strings.Cut(version, ".")
+	return major
+}
@@ -0,0 +1,130 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// newCapabilitiesServer publishes caps (or 404 if nil) and counts fetches
+// and execute requests.
+func newCapabilitiesServer(t *testing.T, caps *Capabilities, fetches, posts *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == WellKnownPath {
+			fetches.Add(1)
+			if r.Header.Get("Authorization") != "Bearer token" {
+				t.Errorf("capabilities request not authenticated")
+			}
+			if caps == nil {
+				http.NotFound(w, r)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(caps)
+			return
+		}
+		posts.Add(1)
+		_, _ = w.Write([]byte(`{"result":{"value":"ok"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientCapabilitiesCachedAndPing(t *testing.T) {
+	var fetches, posts atomic.Int32
+	srv := newCapabilitiesServer(t, &Capabilities{ProtocolVersion: "1.2", Languages: []string{"python"}, Streaming: true}, &fetches, &posts)
+	client, err := NewClient(Config{Endpoint: srv.URL + "/v1/execute", AuthToken: "token"})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	for range 2 {
+		caps, err := client.Capabilities(context.Background())
+		if err != nil {
+			t.Fatalf("Capabilities error: %v", err)
+		}
+		if caps.ProtocolVersion != "1.2" || !caps.Streaming || len(caps.Languages) != 1 {
+			t.Fatalf("unexpected capabilities: %#v", caps)
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Fatalf("fetches = %d, want 1", fetches.Load())
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+	if fetches.Load() != 2 {
+		t.Fatalf("fetches = %d, want 2", fetches.Load())
+	}
+}
+
+func TestClientRefusesUnsupportedRequests(t *testing.T) {
+	var fetches, posts atomic.Int32
+	srv := newCapabilitiesServer(t, &Capabilities{ProtocolVersion: "1.0", Languages: []string{"python"}, MaxTimeoutMs: 1000}, &fetches, &posts)
+	client, err := NewClient(Config{Endpoint: srv.URL, AuthToken: "token", CheckCapabilities: true})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	for name, req := range map[string]remote.RemoteRequest{
+		"stream":   {Stream: true},
+		"language": {Request: remote.ExecutePayload{Language: "javascript"}},
+		"timeout":  {Request: remote.ExecutePayload{TimeoutMillis: 5000}},
+	} {
+		_, err := client.Execute(context.Background(), req)
+		if !errors.Is(err, ErrUnsupported) || !errors.Is(err, remote.ErrRemoteExecutionFailed) {
+			t.Fatalf("%s: expected ErrUnsupported, got %v", name, err)
+		}
+	}
+	if posts.Load() != 0 {
+		t.Fatalf("refused requests were sent: %d", posts.Load())
+	}
+
+	if _, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Language: "python", TimeoutMillis: 500}}); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if posts.Load() != 1 || fetches.Load() != 1 {
+		t.Fatalf("posts = %d, fetches = %d; want 1, 1", posts.Load(), fetches.Load())
+	}
+}
+
+func TestClientRefusesOtherProtocolVersion(t *testing.T) {
+	var fetches, posts atomic.Int32
+	srv := newCapabilitiesServer(t, &Capabilities{ProtocolVersion: "2.0", Streaming: true}, &fetches, &posts)
+	client, err := NewClient(Config{Endpoint: srv.URL, AuthToken: "token", CheckCapabilities: true})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	if _, err := client.Execute(context.Background(), remote.RemoteRequest{}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestClientWithoutCapabilitiesEndpoint(t *testing.T) {
+	var fetches, posts atomic.Int32
+	srv := newCapabilitiesServer(t, nil, &fetches, &posts)
+	client, err := NewClient(Config{Endpoint: srv.URL, AuthToken: "token", CheckCapabilities: true})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	for range 2 {
+		if _, err := client.Execute(context.Background(), remote.RemoteRequest{Stream: true}); err != nil {
+			t.Fatalf("Execute error: %v", err)
+		}
+	}
+	if posts.Load() != 2 || fetches.Load() != 1 {
+		t.Fatalf("posts = %d, fetches = %d; want 2, 1", posts.Load(), fetches.Load())
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+	if _, err := client.Capabilities(context.Background()); !errors.Is(err, ErrCapabilitiesUnknown) {
+		t.Fatalf("expected ErrCapabilitiesUnknown, got %v", err)
+	}
+}
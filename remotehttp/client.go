@@ -2,31 +2,64 @@
 package remotehttp
 
 import (
+	"bytes"
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"crypto/tls"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/jonwraymond/toolexec-integrations/internal/tlsconfig"
 	"github.com/jonwraymond/toolexec/runtime/backend/remote"
 )
 
+// TLSConfig configures TLS for connections to the remote runtime.
+type TLSConfig = tlsconfig.Config
+
 // Config configures the remote HTTP client.
 type Config struct {
-	// Endpoint is the URL of the remote runtime service.
+	// Endpoint is the URL of the remote runtime service. It may be empty
+	// if Endpoints or SRV is set; otherwise it is used first.
 	Endpoint string
 
+	// Endpoints lists further runtime servers to spread requests across.
+	// A streamed or async execution stays on the endpoint that started it.
+	Endpoints []EndpointConfig
+
+	// SRV discovers endpoints from DNS SRV records, in addition to
+	// Endpoint and Endpoints.
+	SRV *SRVConfig
+
+	// LoadBalancing selects the endpoint for each request.
+	// Default: RoundRobin
+	LoadBalancing LoadBalancing
+
+	// HealthCheck configures passive ejection of failing endpoints and
+	// optional active checks. Passive checks use the defaults of
+	// HealthCheckConfig when nil.
+	HealthCheck *HealthCheckConfig
+
 	// AuthToken is the bearer token used for authentication and signing.
 	AuthToken string
 
+	// TokenSource supplies bearer tokens that can change over time and
+	// overrides AuthToken. A 401 response makes the client discard the
+	// rejected token and re-send the request once with a fresh one.
+	TokenSource TokenSource
+
+	// SigningKey enables v2 request signing (see SignatureHeader) with a
+	// key separate from AuthToken. When empty, requests carrying AuthToken
+	// are signed with the legacy v1 scheme.
+	SigningKey []byte
+
+	// SigningKeyID names SigningKey so servers can rotate keys.
+	SigningKeyID string
+
 	// TLSSkipVerify skips TLS certificate verification.
 	// WARNING: Only use for development.
 	TLSSkipVerify bool
@@ -35,6 +68,75 @@ type Config struct {
 	// Default: 3
 	MaxRetries int
 
+	// RetryPolicy decides which failures are retried and how long to wait
+	// between attempts.
+	// Default: ExponentialBackoff{}
+	RetryPolicy RetryPolicy
+
+	// MaxEventSize caps the size of a single streamed SSE event. Larger
+	// events fail the request with ErrEventTooLarge.
+	// Default: 16MiB
+	MaxEventSize int
+
+	// ToolHandlers are host tools that remote code may invoke during an
+	// execution, keyed by tool ID. When any are set, Execute always requests
+	// a stream, since tool requests arrive as stream events. See ToolRequest.
+	ToolHandlers map[string]ToolHandler
+
+	// ToolTimeout bounds each tool callback. A shorter timeout_ms in the
+	// tool request takes precedence.
+	// Default: 30s
+	ToolTimeout time.Duration
+
+	// CancelTimeout bounds the cancel call sent when the caller's context
+	// ends during a streamed or async execution. See EventExecution.
+	// Default: 5s
+	CancelTimeout time.Duration
+
+	// Async runs non-streamed Execute calls as submitted jobs that the
+	// client polls for the result, so no single request stays open for the
+	// whole execution. See PreferHeader. Streamed calls are not affected.
+	Async bool
+
+	// PollInterval is the delay between async status polls when the server
+	// gives no Retry-After hint.
+	// Default: 1s
+	PollInterval time.Duration
+
+	// PollWait asks the server to hold each async status poll open for up
+	// to this long (long polling). It is capped below the client Timeout.
+	// Default: 20s
+	PollWait time.Duration
+
+	// CircuitBreaker, when set, fails execute requests fast with
+	// ErrCircuitOpen while the remote runtime looks unavailable.
+	CircuitBreaker *CircuitBreakerConfig
+
+	// CheckCapabilities makes Execute refuse requests the runtime's
+	// Capabilities rule out, such as streaming against a server that cannot
+	// stream, with ErrUnsupported. Runtimes without a capabilities
+	// endpoint are not checked.
+	CheckCapabilities bool
+
+	// CapabilitiesTTL is how long fetched capabilities are cached.
+	// Default: 5m
+	CapabilitiesTTL time.Duration
+
+	// Compression compresses execute request bodies of at least
+	// CompressionThreshold bytes with CompressionGzip, CompressionZstd or
+	// CompressionAuto. gzip and zstd responses are decoded either way.
+	// Default: "" (requests are sent uncompressed)
+	Compression string
+
+	// CompressionThreshold is the smallest request body that is compressed.
+	// Default: 1KiB
+	CompressionThreshold int
+
+	// TLS configures CA bundles, an mTLS client certificate, server-name
+	// override, certificate pinning and reload of rotated certificate files
+	// for connections to the remote runtime. Ignored if HTTPClient is set.
+	TLS TLSConfig
+
 	// HTTPClient overrides the default HTTP client.
 	HTTPClient *http.Client
 
@@ -47,19 +149,38 @@ type Config struct {
 
 // Client executes remote runtime requests over HTTP.
 type Client struct {
-	endpoint   *url.URL
-	authToken  string
+	pool       *endpointPool
+	health     *healthChecker
+	tokens     TokenSource
 	maxRetries int
+	retry      RetryPolicy
 	httpClient *http.Client
 	logger     remote.Logger
+
+	maxEventSize int
+	signingKey   []byte
+	signingKeyID string
+	tools        map[string]ToolHandler
+	toolTimeout  time.Duration
+
+	cancelTimeout time.Duration
+
+	async        bool
+	pollInterval time.Duration
+	pollWait     time.Duration
+
+	breaker *circuitBreaker
+
+	checkCaps    bool
+	capabilities capabilitiesCache
+
+	compression          string
+	compressionThreshold int
 }
 
 // NewClient creates a new remote HTTP client using the provided configuration.
 func NewClient(cfg Config) (*Client, error) {
-	if cfg.Endpoint == "" {
-		return nil, remote.ErrRemoteNotAvailable
-	}
-	parsed, err := url.Parse(cfg.Endpoint)
+	pool, err := newEndpointPool(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -69,15 +190,45 @@ func NewClient(cfg Config) (*Client, error) {
 		maxRetries = 3
 	}
 
+	tokens := cfg.TokenSource
+	if tokens == nil && cfg.AuthToken != "" {
+		tokens = StaticTokenSource(cfg.AuthToken)
+	}
+
+	maxEventSize := cfg.MaxEventSize
+	if maxEventSize <= 0 {
+		maxEventSize = defaultMaxEventSize
+	}
+
+	toolTimeout := cfg.ToolTimeout
+	if toolTimeout <= 0 {
+		toolTimeout = 30 * time.Second
+	}
+
+	cancelTimeout := cfg.CancelTimeout
+	if cancelTimeout <= 0 {
+		cancelTimeout = 5 * time.Second
+	}
+
+	pollInterval := cfg.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+
+	retry := cfg.RetryPolicy
+	if retry == nil {
+		retry = ExponentialBackoff{}
+	}
+
 	client := cfg.HTTPClient
 	if client == nil {
 		timeout := cfg.Timeout
 		if timeout == 0 {
 			timeout = 30 * time.Second
 		}
-		transport := http.DefaultTransport.(*http.Transport).Clone()
-		if cfg.TLSSkipVerify {
-			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // #nosec G402 -- explicitly opt-in for local/test endpoints
+		transport, err := tlsconfig.Transport(http.DefaultTransport.(*http.Transport).Clone(), cfg.TLS, cfg.TLSSkipVerify)
+		if err != nil {
+			return nil, fmt.Errorf("tls config: %w", err)
 		}
 		client = &http.Client{
 			Transport: transport,
@@ -85,99 +236,280 @@ func NewClient(cfg Config) (*Client, error) {
 		}
 	}
 
+	switch cfg.Compression {
+	case "", CompressionGzip, CompressionZstd, CompressionAuto:
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", cfg.Compression)
+	}
+	compressionThreshold := cfg.CompressionThreshold
+	if compressionThreshold <= 0 {
+		compressionThreshold = 1 << 10
+	}
+
+	capabilitiesTTL := cfg.CapabilitiesTTL
+	if capabilitiesTTL <= 0 {
+		capabilitiesTTL = 5 * time.Minute
+	}
+
+	pollWait := cfg.PollWait
+	if pollWait <= 0 {
+		pollWait = 20 * time.Second
+	}
+	if client.Timeout > 0 && pollWait > client.Timeout/2 {
+		pollWait = client.Timeout / 2
+	}
+
 	return &Client{
-		endpoint:   parsed,
-		authToken:  cfg.AuthToken,
+		pool:       pool,
+		health:     startHealthChecker(pool, client, cfg.HealthCheck),
+		tokens:     tokens,
 		maxRetries: maxRetries,
+		retry:      retry,
 		httpClient: client,
 		logger:     cfg.Logger,
+
+		maxEventSize: maxEventSize,
+		signingKey:   bytes.Clone(cfg.SigningKey),
+		signingKeyID: cfg.SigningKeyID,
+		tools:        maps.Clone(cfg.ToolHandlers),
+		toolTimeout:  toolTimeout,
+
+		cancelTimeout: cancelTimeout,
+
+		async:        cfg.Async,
+		pollInterval: pollInterval,
+		pollWait:     pollWait,
+
+		breaker: newCircuitBreaker(cfg.CircuitBreaker, cfg.Logger),
+
+		checkCaps:    cfg.CheckCapabilities,
+		capabilities: capabilitiesCache{ttl: capabilitiesTTL},
+
+		compression:          cfg.Compression,
+		compressionThreshold: compressionThreshold,
 	}, nil
 }
 
-// Endpoint returns the configured endpoint URL.
+// Endpoint returns the URL of the endpoint that served the most recent
+// request, or the first configured endpoint before any request.
 func (c *Client) Endpoint() string {
-	if c.endpoint == nil {
+	if c.pool == nil {
 		return ""
 	}
-	return c.endpoint.String()
+	if u := c.pool.lastUsed(); u != nil {
+		return u.String()
+	}
+	return ""
+}
+
+// Close stops active health checks. The client must not be used after
+// Close. It is only needed if HealthCheck.Interval is set.
+func (c *Client) Close() error {
+	if c.health != nil {
+		c.health.close()
+	}
+	return nil
 }
 
 // Execute runs the request against the remote runtime service.
 func (c *Client) Execute(ctx context.Context, payload remote.RemoteRequest) (remote.RemoteResponse, error) {
+	return c.execute(ctx, payload, nil)
+}
+
+// ExecuteStream runs the request with streaming enabled and delivers events
+// to handler as they arrive. It returns the same aggregated response as
+// Execute. The handler is called synchronously from the reading goroutine
+// and should return quickly.
+func (c *Client) ExecuteStream(ctx context.Context, payload remote.RemoteRequest, handler StreamHandler) (remote.RemoteResponse, error) {
+	payload.Stream = true
+	return c.execute(ctx, payload, handler)
+}
+
+var _ remote.RemoteClient = (*Client)(nil)
+var _ remote.EndpointProvider = (*Client)(nil)
+
+// call is the state of one logical Execute call across attempts.
+type call struct {
+	payload        []byte
+	encoding       string // Content-Encoding of payload
+	stream         bool
+	idempotencyKey string
+	handler        StreamHandler
+
+	// delivered is set once any event reached handler; such calls are not
+	// retried since the caller has already observed output.
+	delivered bool
+
+	// accepted is set once an async submit was accepted; the job is then
+	// polled instead of being submitted again.
+	accepted bool
+}
+
+func (c *call) emit(event StreamEvent) {
+	if c.handler == nil {
+		return
+	}
+	c.delivered = true
+	c.handler(event)
+}
+
+func (c *Client) execute(ctx context.Context, payload remote.RemoteRequest, handler StreamHandler) (remote.RemoteResponse, error) {
+	if len(c.tools) > 0 {
+		payload.Stream = true
+	}
+	if err := c.checkCapabilities(ctx, payload); err != nil {
+		return remote.RemoteResponse{}, err
+	}
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return remote.RemoteResponse{}, fmt.Errorf("%w: marshal request: %v", remote.ErrRemoteExecutionFailed, err)
 	}
-	response, err := c.doRequest(ctx, data, payload.Stream)
+	data, encoding, err := c.compressPayload(ctx, data)
+	if err != nil {
+		return remote.RemoteResponse{}, err
+	}
+	key, err := idempotencyKey(ctx)
+	if err != nil {
+		return remote.RemoteResponse{}, fmt.Errorf("%w: idempotency key: %v", remote.ErrRemoteExecutionFailed, err)
+	}
+	response, err := c.doRequest(ctx, &call{
+		payload:        data,
+		encoding:       encoding,
+		stream:         payload.Stream,
+		idempotencyKey: key,
+		handler:        handler,
+	})
 	if err != nil {
 		return remote.RemoteResponse{}, err
 	}
 	return response, nil
 }
 
-var _ remote.RemoteClient = (*Client)(nil)
-var _ remote.EndpointProvider = (*Client)(nil)
-
-func (c *Client) doRequest(ctx context.Context, payload []byte, stream bool) (remote.RemoteResponse, error) {
+func (c *Client) doRequest(ctx context.Context, call *call) (remote.RemoteResponse, error) {
+	var tried []*endpoint
 	for attempt := 0; attempt <= c.maxRetries; attempt++ {
-		resp, err := c.executeRequest(ctx, payload, stream)
+		resp, target, err := c.attempt(ctx, call, tried)
 		if err == nil {
 			return resp, nil
 		}
-		if !isRetryable(err) || attempt == c.maxRetries {
+		if target != nil {
+			tried = append(tried, target)
+		}
+		if attempt == c.maxRetries || call.delivered || call.accepted {
+			return remote.RemoteResponse{}, err
+		}
+		delay, ok := c.retry.Backoff(attempt+1, err)
+		if !ok {
 			return remote.RemoteResponse{}, err
 		}
+		if errors.Is(err, remote.ErrConnectionFailed) && c.pool.hasUntried(tried) {
+			// Fail over to another endpoint right away.
+			delay = 0
+		}
 		if c.logger != nil {
-			c.logger.Warn("remote execution retry", "attempt", attempt+1, "error", err)
+			c.logger.Warn("remote execution retry", "attempt", attempt+1, "delay", delay, "error", err)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return remote.RemoteResponse{}, err
 		}
 	}
 	return remote.RemoteResponse{}, fmt.Errorf("%w: retries exhausted", remote.ErrRemoteExecutionFailed)
 }
 
-func (c *Client) executeRequest(ctx context.Context, payload []byte, stream bool) (remote.RemoteResponse, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint.String(), strings.NewReader(string(payload)))
+// attempt sends one execute request through the circuit breaker to an
+// endpoint picked by the pool, preferring endpoints not in tried.
+func (c *Client) attempt(ctx context.Context, call *call, tried []*endpoint) (remote.RemoteResponse, *endpoint, error) {
+	var probe bool
+	if c.breaker != nil {
+		var err error
+		if probe, err = c.breaker.allow(); err != nil {
+			return remote.RemoteResponse{}, nil, err
+		}
+	}
+	target, err := c.pool.pick(ctx, tried)
+	if err != nil {
+		if c.breaker != nil {
+			c.breaker.release(probe)
+		}
+		return remote.RemoteResponse{}, nil, err
+	}
+	resp, err := c.executeRequest(ctx, call, target.url)
+
+	// A caller that gave up, or a request that was never sent for lack of
+	// a token, says nothing about the server.
+	unsent := ctx.Err() != nil || errors.Is(err, ErrAuthTokenUnavailable)
+	c.pool.release(target, err, unsent)
+	if c.breaker != nil {
+		if unsent {
+			c.breaker.release(probe)
+		} else {
+			c.breaker.done(probe, err)
+		}
+	}
+	return resp, target, err
+}
+
+func (c *Client) executeRequest(ctx context.Context, call *call, endpoint *url.URL) (remote.RemoteResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), strings.NewReader(string(call.payload)))
 	if err != nil {
 		return remote.RemoteResponse{}, fmt.Errorf("%w: build request: %v", remote.ErrConnectionFailed, err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	if stream {
+	if call.encoding != "" {
+		req.Header.Set("Content-Encoding", call.encoding)
+	}
+	req.Header.Set(IdempotencyKeyHeader, call.idempotencyKey)
+	async := c.async && !call.stream
+	if call.stream {
 		req.Header.Set("Accept", "text/event-stream")
 	}
-	if c.authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+c.authToken)
-		signRequest(req, payload, c.authToken)
+	if async {
+		req.Header.Set(PreferHeader, preferRespondAsync)
 	}
-
 	if c.logger != nil {
-		c.logger.Info("remote execution request", "endpoint", c.endpoint.String(), "stream", stream)
+		c.logger.Info("remote execution request", "endpoint", endpoint.Redacted(), "stream", call.stream, "async", async)
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req, call.payload)
 	if err != nil {
-		return remote.RemoteResponse{}, fmt.Errorf("%w: %v", remote.ErrConnectionFailed, err)
+		return remote.RemoteResponse{}, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode >= 500 {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		return remote.RemoteResponse{}, fmt.Errorf("%w: server error %d: %s", remote.ErrRemoteExecutionFailed, resp.StatusCode, strings.TrimSpace(string(body)))
-	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		return remote.RemoteResponse{}, fmt.Errorf("%w: status %d: %s", remote.ErrRemoteExecutionFailed, resp.StatusCode, strings.TrimSpace(string(body)))
+		return remote.RemoteResponse{}, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(body)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
 	}
 
-	if stream && strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
-		payloadResult, err := readStream(resp.Body)
+	if async && resp.StatusCode == http.StatusAccepted {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+		call.accepted = true
+		job, err := c.acceptAsync(resp, endpoint)
 		if err != nil {
 			return remote.RemoteResponse{}, err
 		}
-		return remote.RemoteResponse{Result: &payloadResult}, nil
+		return c.pollAsync(ctx, call, job)
+	}
+
+	if call.stream && strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		state := &streamState{endpoint: endpoint, executionID: resp.Header.Get(ExecutionIDHeader), tools: c.newToolDispatcher(ctx, endpoint)}
+		defer state.tools.close()
+		err := c.readStream(resp.Body, call, state)
+		if err := c.resumeStream(ctx, call, state, err); err != nil {
+			c.cancelAbandoned(ctx, endpoint, state.executionID, state.done)
+			return remote.RemoteResponse{}, err
+		}
+		return remote.RemoteResponse{Result: &state.result}, nil
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
+		c.cancelAbandoned(ctx, endpoint, resp.Header.Get(ExecutionIDHeader), false)
 		return remote.RemoteResponse{}, fmt.Errorf("%w: read response: %v", remote.ErrRemoteExecutionFailed, err)
 	}
 
@@ -185,65 +517,21 @@ func (c *Client) executeRequest(ctx context.Context, payload []byte, stream bool
 	if err := json.Unmarshal(body, &response); err != nil {
 		return remote.RemoteResponse{}, fmt.Errorf("%w: decode response: %v", remote.ErrRemoteExecutionFailed, err)
 	}
+	emitResponse(call, response)
 
 	return response, nil
 }
 
-func readStream(body io.Reader) (remote.ExecuteResultPayload, error) {
-	decoder := newSSEDecoder(body)
-	var result remote.ExecuteResultPayload
-	for {
-		event, err := decoder.next()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return remote.ExecuteResultPayload{}, fmt.Errorf("%w: stream decode: %v", remote.ErrRemoteExecutionFailed, err)
-		}
-		switch event.Name {
-		case "stdout":
-			result.Stdout += event.Data
-		case "stderr":
-			result.Stderr += event.Data
-		case "result":
-			var payload remote.ExecuteResultPayload
-			if err := json.Unmarshal([]byte(event.Data), &payload); err == nil {
-				if payload.Stdout == "" {
-					payload.Stdout = result.Stdout
-				}
-				if payload.Stderr == "" {
-					payload.Stderr = result.Stderr
-				}
-				if len(payload.ToolCalls) == 0 {
-					payload.ToolCalls = result.ToolCalls
-				}
-				result = payload
-			}
-		case "error":
-			return remote.ExecuteResultPayload{}, fmt.Errorf("%w: %s", remote.ErrRemoteExecutionFailed, event.Data)
-		}
-	}
-	return result, nil
-}
-
-func signRequest(req *http.Request, payload []byte, token string) {
-	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
-	mac := hmac.New(sha256.New, []byte(token))
-	_, _ = mac.Write([]byte(timestamp))
-	_, _ = mac.Write([]byte("."))
-	_, _ = mac.Write(payload)
-	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
-
-	req.Header.Set("X-Toolruntime-Timestamp", timestamp)
-	req.Header.Set("X-Toolruntime-Signature", signature)
-}
-
-func isRetryable(err error) bool {
-	if err == nil {
-		return false
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
 	}
-	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-		return false
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
-	return true
 }
@@ -66,7 +66,7 @@ func TestClientExecuteRetries(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		calls++
 		if calls == 1 {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusServiceUnavailable)
 			return
 		}
 		resp := remote.RemoteResponse{Result: &remote.ExecuteResultPayload{Stdout: "ok"}}
@@ -0,0 +1,72 @@
+package remotehttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/jonwraymond/toolexec-integrations/internal/compression"
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// Request body codings for Config.Compression.
+const (
+	CompressionGzip = compression.Gzip
+	CompressionZstd = compression.Zstd
+
+	// CompressionAuto uses the preferred coding among those the runtime
+	// lists in Capabilities.Compression, and no compression if it lists
+	// none or its capabilities are unavailable.
+	CompressionAuto = "auto"
+)
+
+// compressPayload encodes an execute request body if it is large enough,
+// returning the body to send and its Content-Encoding.
+func (c *Client) compressPayload(ctx context.Context, data []byte) ([]byte, string, error) {
+	if c.compression == "" || len(data) < c.compressionThreshold {
+		return data, "", nil
+	}
+	coding := c.compression
+	if coding == CompressionAuto {
+		caps, err := c.Capabilities(ctx)
+		if err != nil {
+			return data, "", nil
+		}
+		coding = ""
+		for _, supported := range compression.Supported {
+			if slices.Contains(caps.Compression, supported) {
+				coding = supported
+				break
+			}
+		}
+		if coding == "" {
+			return data, "", nil
+		}
+	}
+	compressed, err := compression.Compress(coding, data)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: compress request: %v", remote.ErrRemoteExecutionFailed, err)
+	}
+	return compressed, coding, nil
+}
+
+// decodeResponse replaces a compressed response body with a decoding
+// reader.
+func decodeResponse(resp *http.Response) (*http.Response, error) {
+	coding := resp.Header.Get("Content-Encoding")
+	if coding == "" || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified || resp.ContentLength == 0 {
+		return resp, nil
+	}
+	body, err := compression.NewReader(coding, resp.Body)
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%w: decode response: %v", remote.ErrRemoteExecutionFailed, err)
+	}
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
@@ -0,0 +1,162 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jonwraymond/toolexec-integrations/internal/compression"
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+func TestClientCompressesLargeRequests(t *testing.T) {
+	for _, coding := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(coding, func(t *testing.T) {
+			var mu sync.Mutex
+			var encodings []string
+			handler := Verifier(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoding := r.Header.Get("Content-Encoding")
+				body, err := compression.NewReader(encoding, r.Body)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+					return
+				}
+				var req remote.RemoteRequest
+				if err := json.NewDecoder(body).Decode(&req); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				mu.Lock()
+				encodings = append(encodings, encoding)
+				mu.Unlock()
+				_ = json.NewEncoder(w).Encode(remote.RemoteResponse{Result: &remote.ExecuteResultPayload{Value: len(req.Request.Code)}})
+			}), VerifierOptions{Keys: map[string][]byte{"": []byte("secret")}})
+			srv := httptest.NewServer(handler)
+			defer srv.Close()
+
+			client, err := NewClient(Config{Endpoint: srv.URL, SigningKey: []byte("secret"), Compression: coding})
+			if err != nil {
+				t.Fatalf("NewClient error: %v", err)
+			}
+			for _, code := range []string{strings.Repeat("x", 4096), "small"} {
+				resp, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: code}})
+				if err != nil {
+					t.Fatalf("Execute error: %v", err)
+				}
+				if resp.Result == nil || resp.Result.Value != float64(len(code)) {
+					t.Fatalf("unexpected result: %#v", resp.Result)
+				}
+			}
+			if len(encodings) != 2 || encodings[0] != coding || encodings[1] != "" {
+				t.Fatalf("encodings = %q, want [%s \"\"]", encodings, coding)
+			}
+		})
+	}
+}
+
+func TestClientCompressionAuto(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		supported []string
+		want      string
+	}{
+		{name: "preferred", supported: []string{"gzip", "zstd"}, want: CompressionZstd},
+		{name: "gzip only", supported: []string{"gzip"}, want: CompressionGzip},
+		{name: "none", want: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var encoding atomic.Value
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == WellKnownPath {
+					_ = json.NewEncoder(w).Encode(Capabilities{ProtocolVersion: ProtocolVersion, Compression: tc.supported})
+					return
+				}
+				encoding.Store(r.Header.Get("Content-Encoding"))
+				_, _ = w.Write([]byte(`{"result":{"value":"ok"}}`))
+			}))
+			defer srv.Close()
+
+			client, err := NewClient(Config{Endpoint: srv.URL + "/v1/execute", Compression: CompressionAuto, CompressionThreshold: 1})
+			if err != nil {
+				t.Fatalf("NewClient error: %v", err)
+			}
+			if _, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "x"}}); err != nil {
+				t.Fatalf("Execute error: %v", err)
+			}
+			if got := encoding.Load(); got != tc.want {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClientDecodesCompressedResponses(t *testing.T) {
+	result, _ := json.Marshal(remote.RemoteResponse{Result: &remote.ExecuteResultPayload{Value: "ok"}})
+	events := "event: stdout\ndata: hello\n\nevent: result\ndata: {\"value\":\"ok\"}\n\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Accept-Encoding"); got != compression.AcceptEncoding {
+			t.Errorf("Accept-Encoding = %q", got)
+		}
+		body, contentType, coding := result, "application/json", CompressionGzip
+		if r.Header.Get("Accept") == "text/event-stream" {
+			body, contentType, coding = []byte(events), "text/event-stream", CompressionZstd
+		}
+		compressed, err := compression.Compress(coding, body)
+		if err != nil {
+			t.Errorf("Compress error: %v", err)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", coding)
+		_, _ = w.Write(compressed)
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	resp, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "x"}})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Value != "ok" {
+		t.Fatalf("unexpected JSON result: %#v", resp.Result)
+	}
+
+	var stdout strings.Builder
+	resp, err = client.ExecuteStream(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "x"}}, func(event StreamEvent) {
+		if event.Name == EventStdout {
+			stdout.WriteString(event.Data)
+		}
+	})
+	if err != nil {
+		t.Fatalf("ExecuteStream error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Value != "ok" || stdout.String() != "hello" {
+		t.Fatalf("unexpected stream result: %#v, stdout %q", resp.Result, stdout.String())
+	}
+}
+
+func TestDecodeResponseRejectsUnknownEncoding(t *testing.T) {
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Encoding": {"br"}},
+		Body:          io.NopCloser(strings.NewReader("data")),
+		ContentLength: 4,
+	}
+	if _, err := decodeResponse(resp); err == nil {
+		t.Fatalf("expected error for unknown encoding")
+	}
+}
+
+func TestNewClientRejectsUnknownCompression(t *testing.T) {
+	if _, err := NewClient(Config{Endpoint: "http://localhost", Compression: "br"}); err == nil {
+		t.Fatalf("expected error for unknown compression")
+	}
+}
@@ -0,0 +1,412 @@
+// Package conformance checks that a remote runtime speaks the protocol
+// remotehttp.Client expects. Run drives a battery of scenarios against an
+// endpoint or http.Handler and reports pass/fail per case.
+//
+// Scenarios need the runtime to print, fail and stream on demand, so the
+// caller supplies Programs that produce code in the runtime's language.
+// ReferencePrograms and ReferenceExecutor pair up for the reference server.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonwraymond/toolexec-integrations/remotehttp"
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// Programs generates code for the runtime under test.
+type Programs interface {
+	// Output returns code that writes stdout and stderr verbatim and
+	// evaluates to the string value.
+	Output(stdout, stderr, value string) string
+
+	// Fail returns code that fails with an error whose message contains
+	// message.
+	Fail(message string) string
+
+	// Slow returns code that writes each chunk to stdout, flushing it and
+	// pausing interval after each one, and evaluates to "done".
+	Slow(chunks []string, interval time.Duration) string
+}
+
+// Target describes the runtime under test.
+type Target struct {
+	// Endpoint is the URL of a running runtime. Ignored if Handler is set.
+	Endpoint string
+
+	// Handler is served on a local test server for the duration of Run.
+	Handler http.Handler
+
+	// Client configures the clients used by the scenarios. Its Endpoint is
+	// replaced. AuthToken and SigningKey also enable the cases checking
+	// that unauthenticated or badly signed requests are rejected.
+	Client remotehttp.Config
+
+	// Language is sent as the request language.
+	Language string
+
+	// Programs generates code for the scenarios. Required.
+	Programs Programs
+
+	// CaseTimeout bounds each case.
+	// Default: 30s
+	CaseTimeout time.Duration
+}
+
+// Result is the outcome of one case.
+type Result struct {
+	Name     string
+	Passed   bool
+	Skipped  bool
+	Err      error
+	Duration time.Duration
+}
+
+// Report lists the outcomes of a run.
+type Report struct {
+	Results []Result
+}
+
+// Failed reports whether any case failed.
+func (r Report) Failed() bool {
+	for _, result := range r.Results {
+		if !result.Passed && !result.Skipped {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	for _, result := range r.Results {
+		status := "PASS"
+		switch {
+		case result.Skipped:
+			status = "SKIP"
+		case !result.Passed:
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "%s %s (%s)", status, result.Name, result.Duration.Round(time.Millisecond))
+		if result.Err != nil {
+			fmt.Fprintf(&b, ": %v", result.Err)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// errSkip marks a case that does not apply to the target.
+var errSkip = errors.New("not applicable")
+
+type testCase struct {
+	name string
+	run  func(s *suite, ctx context.Context) error
+}
+
+var cases = []testCase{
+	{"json_result", (*suite).jsonResult},
+	{"sse_result", (*suite).sseResult},
+	{"json_error", (*suite).jsonError},
+	{"sse_error", (*suite).sseError},
+	{"large_payload", (*suite).largePayload},
+	{"slow_stream", (*suite).slowStream},
+	{"cancellation", (*suite).cancellation},
+	{"auth_rejected", (*suite).authRejected},
+	{"signature_rejected", (*suite).signatureRejected},
+}
+
+// Run executes every case against target.
+func Run(ctx context.Context, target Target) Report {
+	var report Report
+	s, cleanup, err := newSuite(target)
+	if err != nil {
+		for _, tc := range cases {
+			report.Results = append(report.Results, Result{Name: tc.name, Err: err})
+		}
+		return report
+	}
+	defer cleanup()
+
+	for _, tc := range cases {
+		caseCtx, cancel := context.WithTimeout(ctx, s.timeout)
+		start := time.Now()
+		err := tc.run(s, caseCtx)
+		cancel()
+		result := Result{Name: tc.name, Duration: time.Since(start)}
+		switch {
+		case errors.Is(err, errSkip):
+			result.Skipped = true
+		case err != nil:
+			result.Err = err
+		default:
+			result.Passed = true
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// RunTest runs the suite as subtests of t.
+func RunTest(t *testing.T, target Target) {
+	t.Helper()
+	report := Run(context.Background(), target)
+	for _, result := range report.Results {
+		t.Run(result.Name, func(t *testing.T) {
+			switch {
+			case result.Skipped:
+				t.Skip("not applicable to target")
+			case !result.Passed:
+				t.Fatal(result.Err)
+			}
+		})
+	}
+}
+
+type suite struct {
+	target   Target
+	endpoint string
+	timeout  time.Duration
+	client   *remotehttp.Client
+}
+
+func newSuite(target Target) (*suite, func(), error) {
+	if target.Programs == nil {
+		return nil, nil, errors.New("conformance: Programs is required")
+	}
+	cleanup := func() {}
+	endpoint := target.Endpoint
+	if target.Handler != nil {
+		srv := httptest.NewServer(target.Handler)
+		endpoint, cleanup = srv.URL, srv.Close
+	}
+	if endpoint == "" {
+		return nil, nil, errors.New("conformance: Endpoint or Handler is required")
+	}
+	timeout := target.CaseTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	s := &suite{target: target, endpoint: endpoint, timeout: timeout}
+	client, err := s.newClient(target.Client)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	s.client = client
+	return s, cleanup, nil
+}
+
+func (s *suite) newClient(cfg remotehttp.Config) (*remotehttp.Client, error) {
+	cfg.Endpoint = s.endpoint
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 1
+	}
+	return remotehttp.NewClient(cfg)
+}
+
+func (s *suite) request(code string, stream bool) remote.RemoteRequest {
+	return remote.RemoteRequest{
+		Request: remote.ExecutePayload{Language: s.target.Language, Code: code},
+		Stream:  stream,
+	}
+}
+
+func (s *suite) checkOutput(resp remote.RemoteResponse, stdout, stderr, value string) error {
+	if resp.Error != nil {
+		return fmt.Errorf("unexpected error response: %s: %s", resp.Error.Code, resp.Error.Message)
+	}
+	if resp.Result == nil {
+		return errors.New("response has no result")
+	}
+	if resp.Result.Stdout != stdout {
+		return fmt.Errorf("stdout = %q, want %q", clip(resp.Result.Stdout), clip(stdout))
+	}
+	if resp.Result.Stderr != stderr {
+		return fmt.Errorf("stderr = %q, want %q", clip(resp.Result.Stderr), clip(stderr))
+	}
+	if got := fmt.Sprint(resp.Result.Value); got != value {
+		return fmt.Errorf("value = %q, want %q", clip(got), clip(value))
+	}
+	return nil
+}
+
+// Output with leading whitespace and blank lines catches servers and
+// decoders that trim data.
+const (
+	sampleStdout = "  indented\n\nline 3\n"
+	sampleStderr = "warning: x\n"
+)
+
+func (s *suite) jsonResult(ctx context.Context) error {
+	resp, err := s.client.Execute(ctx, s.request(s.target.Programs.Output(sampleStdout, sampleStderr, "42"), false))
+	if err != nil {
+		return err
+	}
+	return s.checkOutput(resp, sampleStdout, sampleStderr, "42")
+}
+
+func (s *suite) sseResult(ctx context.Context) error {
+	var names []string
+	resp, err := s.client.ExecuteStream(ctx, s.request(s.target.Programs.Output(sampleStdout, sampleStderr, "42"), true), func(event remotehttp.StreamEvent) {
+		names = append(names, event.Name)
+	})
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 || names[len(names)-1] != remotehttp.EventResult {
+		return fmt.Errorf("stream events %v do not end with a result event", names)
+	}
+	return s.checkOutput(resp, sampleStdout, sampleStderr, "42")
+}
+
+func (s *suite) jsonError(ctx context.Context) error {
+	resp, err := s.client.Execute(ctx, s.request(s.target.Programs.Fail("conformance failure"), false))
+	if err != nil {
+		return err
+	}
+	if resp.Error == nil {
+		return errors.New("failing program returned no error")
+	}
+	if !strings.Contains(resp.Error.Message, "conformance failure") {
+		return fmt.Errorf("error message %q does not contain the failure", resp.Error.Message)
+	}
+	return nil
+}
+
+func (s *suite) sseError(ctx context.Context) error {
+	var sawError bool
+	_, err := s.client.ExecuteStream(ctx, s.request(s.target.Programs.Fail("conformance failure"), true), func(event remotehttp.StreamEvent) {
+		sawError = sawError || event.Name == remotehttp.EventError
+	})
+	if err == nil {
+		return errors.New("failing program returned no error")
+	}
+	if !sawError || !strings.Contains(err.Error(), "conformance failure") {
+		return fmt.Errorf("expected an error event with the failure, got %v", err)
+	}
+	return nil
+}
+
+func (s *suite) largePayload(ctx context.Context) error {
+	stdout := strings.Repeat(strings.Repeat("x", 1023)+"\n", 2048) // 2MiB
+	for _, stream := range []bool{false, true} {
+		resp, err := s.client.Execute(ctx, s.request(s.target.Programs.Output(stdout, "", "ok"), stream))
+		if err != nil {
+			return fmt.Errorf("stream=%v: %w", stream, err)
+		}
+		if err := s.checkOutput(resp, stdout, "", "ok"); err != nil {
+			return fmt.Errorf("stream=%v: %w", stream, err)
+		}
+	}
+	return nil
+}
+
+func (s *suite) slowStream(ctx context.Context) error {
+	const interval = 300 * time.Millisecond
+	chunks := []string{"one\n", "two\n", "three\n"}
+	start := time.Now()
+	var firstOutput, resultAt time.Duration
+	resp, err := s.client.ExecuteStream(ctx, s.request(s.target.Programs.Slow(chunks, interval), true), func(event remotehttp.StreamEvent) {
+		switch event.Name {
+		case remotehttp.EventStdout:
+			if firstOutput == 0 {
+				firstOutput = time.Since(start)
+			}
+		case remotehttp.EventResult:
+			resultAt = time.Since(start)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.checkOutput(resp, strings.Join(chunks, ""), "", "done"); err != nil {
+		return err
+	}
+	if firstOutput == 0 || resultAt-firstOutput < interval {
+		return fmt.Errorf("output was not streamed live: first output at %s, result at %s", firstOutput, resultAt)
+	}
+	return nil
+}
+
+func (s *suite) cancellation(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chunks := make([]string, 100)
+	for i := range chunks {
+		chunks[i] = "tick\n"
+	}
+	var canceledAt time.Time
+	_, err := s.client.ExecuteStream(ctx, s.request(s.target.Programs.Slow(chunks, 100*time.Millisecond), true), func(event remotehttp.StreamEvent) {
+		if event.Name == remotehttp.EventStdout && canceledAt.IsZero() {
+			canceledAt = time.Now()
+			cancel()
+		}
+	})
+	if canceledAt.IsZero() {
+		return fmt.Errorf("no output before cancellation: %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("expected context.Canceled, got %v", err)
+	}
+	if d := time.Since(canceledAt); d > 5*time.Second {
+		return fmt.Errorf("call returned %s after cancellation", d)
+	}
+	return nil
+}
+
+func (s *suite) authRejected(ctx context.Context) error {
+	if s.target.Client.AuthToken == "" && s.target.Client.TokenSource == nil {
+		return errSkip
+	}
+	cfg := s.target.Client
+	cfg.AuthToken, cfg.TokenSource = "", nil
+	cfg.SigningKey = nil
+	return s.expectRejected(ctx, cfg)
+}
+
+func (s *suite) signatureRejected(ctx context.Context) error {
+	if len(s.target.Client.SigningKey) == 0 {
+		return errSkip
+	}
+	cfg := s.target.Client
+	cfg.SigningKey = append([]byte("wrong-"), cfg.SigningKey...)
+	if err := s.expectRejected(ctx, cfg); err != nil {
+		return fmt.Errorf("wrong key: %w", err)
+	}
+	cfg.SigningKey = nil
+	if err := s.expectRejected(ctx, cfg); err != nil {
+		return fmt.Errorf("unsigned: %w", err)
+	}
+	return nil
+}
+
+func (s *suite) expectRejected(ctx context.Context, cfg remotehttp.Config) error {
+	client, err := s.newClient(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = client.Execute(ctx, s.request(s.target.Programs.Output("", "", "ok"), false))
+	var statusErr *remotehttp.StatusError
+	if !errors.As(err, &statusErr) {
+		return fmt.Errorf("expected 401 or 403, got %v", err)
+	}
+	if statusErr.StatusCode != http.StatusUnauthorized && statusErr.StatusCode != http.StatusForbidden {
+		return fmt.Errorf("expected 401 or 403, got %d", statusErr.StatusCode)
+	}
+	return nil
+}
+
+func clip(s string) string {
+	if len(s) <= 64 {
+		return s
+	}
+	return fmt.Sprintf("%s...(%d bytes)", s[:64], len(s))
+}
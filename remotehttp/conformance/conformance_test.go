@@ -0,0 +1,94 @@
+package conformance
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jonwraymond/toolexec-integrations/remotehttp"
+	"github.com/jonwraymond/toolexec-integrations/remotehttp/server"
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+func newReferenceHandler(t *testing.T, cfg server.Config) *server.Handler {
+	t.Helper()
+	if cfg.Executor == nil {
+		cfg.Executor = ReferenceExecutor
+	}
+	handler, err := server.NewHandler(cfg)
+	if err != nil {
+		t.Fatalf("NewHandler error: %v", err)
+	}
+	t.Cleanup(handler.Close)
+	return handler
+}
+
+func TestReferenceServerConforms(t *testing.T) {
+	key := []byte("signing-key")
+	handler := newReferenceHandler(t, server.Config{
+		AuthToken: "token",
+		Verifier:  &remotehttp.VerifierOptions{Keys: map[string][]byte{"k1": key}},
+	})
+
+	RunTest(t, Target{
+		Handler:  handler,
+		Client:   remotehttp.Config{AuthToken: "token", SigningKey: key, SigningKeyID: "k1"},
+		Programs: ReferencePrograms{},
+	})
+}
+
+func TestRunReportsFailures(t *testing.T) {
+	// A server that trims output whitespace breaks the JSON and SSE cases.
+	trimming := server.ExecutorFunc(func(ctx context.Context, req remote.RemoteRequest, out server.Output) (remote.ExecuteResultPayload, error) {
+		return ReferenceExecutor.Execute(ctx, req, trimmingOutput{out})
+	})
+	report := Run(context.Background(), Target{
+		Handler:  newReferenceHandler(t, server.Config{Executor: trimming}),
+		Programs: ReferencePrograms{},
+	})
+	if !report.Failed() {
+		t.Fatalf("expected failures:\n%s", report)
+	}
+	status := map[string]Result{}
+	for _, result := range report.Results {
+		status[result.Name] = result
+	}
+	for _, name := range []string{"json_result", "sse_result"} {
+		if status[name].Passed || status[name].Err == nil {
+			t.Fatalf("%s passed:\n%s", name, report)
+		}
+	}
+	for _, name := range []string{"auth_rejected", "signature_rejected"} {
+		if !status[name].Skipped {
+			t.Fatalf("%s not skipped:\n%s", name, report)
+		}
+	}
+	if !strings.Contains(report.String(), "FAIL json_result") {
+		t.Fatalf("report missing failure:\n%s", report)
+	}
+}
+
+func TestRunRejectsOpenServerWhenAuthConfigured(t *testing.T) {
+	report := Run(context.Background(), Target{
+		Handler:  http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }),
+		Client:   remotehttp.Config{AuthToken: "token"},
+		Programs: ReferencePrograms{},
+	})
+	for _, result := range report.Results {
+		if result.Name == "auth_rejected" && (result.Passed || result.Skipped) {
+			t.Fatalf("auth_rejected passed against an open server:\n%s", report)
+		}
+	}
+}
+
+func TestRunRequiresPrograms(t *testing.T) {
+	report := Run(context.Background(), Target{Endpoint: "http://127.0.0.1:1"})
+	if !report.Failed() || len(report.Results) != len(cases) {
+		t.Fatalf("unexpected report:\n%s", report)
+	}
+}
+
+type trimmingOutput struct{ server.Output }
+
+func (o trimmingOutput) Stdout(s string) { o.Output.Stdout(strings.TrimSpace(s)) }
@@ -0,0 +1,77 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jonwraymond/toolexec-integrations/remotehttp/server"
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// ReferencePrograms generates code for ReferenceExecutor: a JSON document
+// describing the behavior instead of a real program.
+type ReferencePrograms struct{}
+
+type referenceProgram struct {
+	Stdout     string   `json:"stdout,omitempty"`
+	Stderr     string   `json:"stderr,omitempty"`
+	Value      string   `json:"value,omitempty"`
+	Fail       string   `json:"fail,omitempty"`
+	Chunks     []string `json:"chunks,omitempty"`
+	IntervalMs int64    `json:"interval_ms,omitempty"`
+}
+
+func (ReferencePrograms) encode(p referenceProgram) string {
+	data, _ := json.Marshal(p)
+	return string(data)
+}
+
+// Output implements Programs.
+func (r ReferencePrograms) Output(stdout, stderr, value string) string {
+	return r.encode(referenceProgram{Stdout: stdout, Stderr: stderr, Value: value})
+}
+
+// Fail implements Programs.
+func (r ReferencePrograms) Fail(message string) string {
+	return r.encode(referenceProgram{Fail: message})
+}
+
+// Slow implements Programs.
+func (r ReferencePrograms) Slow(chunks []string, interval time.Duration) string {
+	return r.encode(referenceProgram{Chunks: chunks, IntervalMs: interval.Milliseconds(), Value: "done"})
+}
+
+// ReferenceExecutor is a server.Executor that runs ReferencePrograms code.
+// It lets the suite exercise server.Handler, or a server built on it,
+// without a real language runtime.
+var ReferenceExecutor server.Executor = server.ExecutorFunc(runReference)
+
+func runReference(ctx context.Context, req remote.RemoteRequest, out server.Output) (remote.ExecuteResultPayload, error) {
+	var p referenceProgram
+	if err := json.Unmarshal([]byte(req.Request.Code), &p); err != nil {
+		return remote.ExecuteResultPayload{}, &server.Error{Code: "invalid_program", Message: fmt.Sprintf("decode program: %v", err)}
+	}
+	if p.Fail != "" {
+		return remote.ExecuteResultPayload{}, errors.New(p.Fail)
+	}
+	if p.Stdout != "" {
+		out.Stdout(p.Stdout)
+	}
+	if p.Stderr != "" {
+		out.Stderr(p.Stderr)
+	}
+	for _, chunk := range p.Chunks {
+		out.Stdout(chunk)
+		timer := time.NewTimer(time.Duration(p.IntervalMs) * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return remote.ExecuteResultPayload{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return remote.ExecuteResultPayload{Value: p.Value}, nil
+}
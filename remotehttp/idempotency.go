@@ -0,0 +1,258 @@
+package remotehttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader carries the idempotency key of a logical Execute call.
+//
+// Server contract: every attempt of one Execute call (including retries)
+// carries the same key. A server that has already completed a request with
+// that key must not execute the code again; it replays the recorded status,
+// headers (such as Location and X-Toolruntime-Execution-Id) and body
+// instead. A request that reuses a key with a
+// different body is rejected with 422. While the first request is still
+// running, duplicates wait for it and receive its response. 429 and 5xx
+// responses are not recorded, since they signal the work did not complete;
+// neither are responses the server never finished. Keys are scoped to the
+// caller, so two callers that pick the same key do not share responses.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type idempotencyKeyContextKey struct{}
+
+// WithIdempotencyKey returns a context that makes Execute use key instead of
+// generating one. Use it when the caller itself retries Execute.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+func idempotencyKey(ctx context.Context) (string, error) {
+	if key, ok := ctx.Value(idempotencyKeyContextKey{}).(string); ok && key != "" {
+		return key, nil
+	}
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// IdempotencyOptions configures IdempotencyHandler.
+type IdempotencyOptions struct {
+	// TTL is how long completed responses are replayable.
+	// Default: 24h
+	TTL time.Duration
+
+	// Principal identifies the caller a key belongs to; keys of different
+	// principals never share a response.
+	// Default: the KeyIDHeader value and a hash of the Authorization header
+	Principal func(*http.Request) string
+
+	// MaxRecordBytes caps the response body kept per key. Larger responses,
+	// such as long event streams, are passed through but not recorded, so a
+	// retry runs again.
+	// Default: 1MiB
+	MaxRecordBytes int64
+}
+
+// IdempotencyHandler is a reference server-side implementation of the
+// IdempotencyKeyHeader contract. It wraps the handler that executes code and
+// keeps recorded responses in memory, so it only dedupes within one process.
+// Wrap it in Verifier so the default principal is authenticated.
+//
+// next is served with a context that is not canceled when the first client
+// disconnects, so a retry after a client timeout finds the finished response
+// to replay. A response is only recorded if next wrote one and returned
+// without panicking.
+func IdempotencyHandler(next http.Handler, opts IdempotencyOptions) http.Handler {
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	principal := opts.Principal
+	if principal == nil {
+		principal = defaultPrincipal
+	}
+	maxRecord := opts.MaxRecordBytes
+	if maxRecord <= 0 {
+		maxRecord = 1 << 20
+	}
+	return &idempotencyHandler{next: next, ttl: ttl, principal: principal, maxRecord: maxRecord, entries: map[string]*idempotencyEntry{}}
+}
+
+// defaultPrincipal identifies the caller by signing key ID and bearer
+// credentials. The credentials are hashed so they are not kept in memory.
+func defaultPrincipal(r *http.Request) string {
+	auth := sha256.Sum256([]byte(r.Header.Get("Authorization")))
+	return r.Header.Get(KeyIDHeader) + "\x00" + hex.EncodeToString(auth[:])
+}
+
+type idempotencyHandler struct {
+	next      http.Handler
+	ttl       time.Duration
+	principal func(*http.Request) string
+	maxRecord int64
+
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+
+	// Set before done is closed.
+	recorded bool
+	status   int
+	header   http.Header
+	body     []byte
+	expires  time.Time
+}
+
+func (h *idempotencyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "read request body", http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	fingerprint := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+	key = h.principal(r) + "\x00" + key
+
+	for {
+		h.mu.Lock()
+		h.evictExpired(time.Now())
+		entry, ok := h.entries[key]
+		if !ok {
+			entry = &idempotencyEntry{fingerprint: fingerprint, done: make(chan struct{})}
+			h.entries[key] = entry
+			h.mu.Unlock()
+			h.execute(w, r, key, entry)
+			return
+		}
+		h.mu.Unlock()
+
+		if entry.fingerprint != fingerprint {
+			http.Error(w, "idempotency key reused with a different request", http.StatusUnprocessableEntity)
+			return
+		}
+		select {
+		case <-entry.done:
+		case <-r.Context().Done():
+			return
+		}
+		if entry.recorded {
+			for name, values := range entry.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(entry.status)
+			_, _ = w.Write(entry.body)
+			return
+		}
+		// The first attempt was not recorded (e.g. 503 or a panic); loop to
+		// run again.
+	}
+}
+
+func (h *idempotencyHandler) execute(w http.ResponseWriter, r *http.Request, key string, entry *idempotencyEntry) {
+	rec := &recordingWriter{ResponseWriter: w, limit: h.maxRecord}
+	ctx := context.WithoutCancel(r.Context())
+	finished := false
+	defer func() {
+		h.mu.Lock()
+		if !finished || !rec.wroteHeader || rec.truncated || rec.status == http.StatusTooManyRequests || rec.status >= 500 {
+			delete(h.entries, key)
+		} else {
+			entry.recorded = true
+			entry.status = rec.status
+			entry.header = rec.header
+			entry.body = rec.body.Bytes()
+			entry.expires = time.Now().Add(h.ttl)
+		}
+		h.mu.Unlock()
+		close(entry.done)
+	}()
+	h.next.ServeHTTP(rec, r.WithContext(ctx))
+	finished = true
+}
+
+// evictExpired drops completed entries past their TTL, at most once a
+// minute. Callers hold h.mu.
+func (h *idempotencyHandler) evictExpired(now time.Time) {
+	if now.Sub(h.lastSweep) < time.Minute {
+		return
+	}
+	h.lastSweep = now
+	for key, entry := range h.entries {
+		if entry.recorded && now.After(entry.expires) {
+			delete(h.entries, key)
+		}
+	}
+}
+
+// hopByHopHeaders apply to a single connection and are not replayed
+// (RFC 9110 section 7.6.1).
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// recordingWriter tees a response to the client and a buffer of at most
+// limit bytes. Writes to a disconnected client are ignored so the response
+// is still recorded.
+type recordingWriter struct {
+	http.ResponseWriter
+	limit       int64
+	status      int
+	header      http.Header
+	wroteHeader bool
+	truncated   bool
+	body        bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	w.header = w.Header().Clone()
+	for _, name := range hopByHopHeaders {
+		w.header.Del(name)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.truncated && int64(w.body.Len()+len(p)) > w.limit {
+		w.truncated = true
+		w.body = bytes.Buffer{}
+	}
+	if !w.truncated {
+		w.body.Write(p)
+	}
+	_, _ = w.ResponseWriter.Write(p)
+	return len(p), nil
+}
+
+func (w *recordingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,267 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+func TestClientExecuteReusesIdempotencyKeyAcrossRetries(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		attempt := len(keys)
+		mu.Unlock()
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(remote.RemoteResponse{Result: &remote.ExecuteResultPayload{Stdout: "ok"}})
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{Endpoint: srv.URL, MaxRetries: 1})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	req := remote.RemoteRequest{Request: remote.ExecutePayload{Code: "return"}}
+	if _, err := client.Execute(context.Background(), req); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if _, err := client.Execute(WithIdempotencyKey(context.Background(), "caller-key"), req); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+
+	if len(keys) != 3 {
+		t.Fatalf("requests = %d, want 3", len(keys))
+	}
+	if keys[0] == "" || keys[0] != keys[1] {
+		t.Fatalf("retry used a different key: %q vs %q", keys[0], keys[1])
+	}
+	if keys[2] != "caller-key" {
+		t.Fatalf("caller key = %q", keys[2])
+	}
+}
+
+func TestIdempotencyHandlerReplaysCompletedResponse(t *testing.T) {
+	var executions atomic.Int32
+	handler := IdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := executions.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(remote.RemoteResponse{Result: &remote.ExecuteResultPayload{Value: float64(n)}})
+	}), IdempotencyOptions{})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	client, err := NewClient(Config{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	ctx := WithIdempotencyKey(context.Background(), "key-1")
+	req := remote.RemoteRequest{Request: remote.ExecutePayload{Code: "charge()"}}
+
+	first, err := client.Execute(ctx, req)
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	second, err := client.Execute(ctx, req)
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if executions.Load() != 1 {
+		t.Fatalf("executions = %d, want 1", executions.Load())
+	}
+	if first.Result.Value != second.Result.Value {
+		t.Fatalf("replayed value %v, want %v", second.Result.Value, first.Result.Value)
+	}
+}
+
+func TestIdempotencyHandlerRejectsKeyReuse(t *testing.T) {
+	handler := IdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), IdempotencyOptions{})
+
+	send := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := send(`{"request":{"code":"a"}}`); code != http.StatusOK {
+		t.Fatalf("first status = %d", code)
+	}
+	if code := send(`{"request":{"code":"b"}}`); code != http.StatusUnprocessableEntity {
+		t.Fatalf("reuse status = %d, want 422", code)
+	}
+}
+
+func TestIdempotencyHandlerDoesNotRecordUnavailable(t *testing.T) {
+	var executions atomic.Int32
+	handler := IdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if executions.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "done")
+	}), IdempotencyOptions{})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if executions.Load() != 2 {
+		t.Fatalf("executions = %d, want 2", executions.Load())
+	}
+}
+
+func TestIdempotencyHandlerReplaysHeaders(t *testing.T) {
+	var executions atomic.Int32
+	handler := IdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		id := fmt.Sprintf("exec-%d", executions.Add(1))
+		w.Header().Set("Location", "/v1/execute?execution_id="+id)
+		w.Header().Set(ExecutionIDHeader, id)
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusAccepted)
+	}), IdempotencyOptions{})
+
+	var locations []string
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/v1/execute", strings.NewReader("{}"))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		req.Header.Set(PreferHeader, preferRespondAsync)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusAccepted || rec.Header().Get(ExecutionIDHeader) != "exec-1" {
+			t.Fatalf("status = %d, execution ID = %q", rec.Code, rec.Header().Get(ExecutionIDHeader))
+		}
+		locations = append(locations, rec.Header().Get("Location"))
+		if len(locations) == 2 && rec.Header().Get("Connection") != "" {
+			t.Fatalf("hop-by-hop header replayed")
+		}
+	}
+	if executions.Load() != 1 {
+		t.Fatalf("executions = %d, want 1", executions.Load())
+	}
+	if locations[0] == "" || locations[0] != locations[1] {
+		t.Fatalf("locations = %q, want the same", locations)
+	}
+}
+
+func TestIdempotencyHandlerDoesNotRecordUnfinished(t *testing.T) {
+	for name, next := range map[string]http.HandlerFunc{
+		"no response": func(http.ResponseWriter, *http.Request) {},
+		"panic": func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, "partial")
+			panic(http.ErrAbortHandler)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var executions atomic.Int32
+			handler := IdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if executions.Add(1) == 1 {
+					next(w, r)
+					return
+				}
+				_, _ = io.WriteString(w, "done")
+			}), IdempotencyOptions{})
+
+			serve := func() *httptest.ResponseRecorder {
+				defer func() { _ = recover() }()
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+				req.Header.Set(IdempotencyKeyHeader, "key-1")
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec
+			}
+			serve()
+			if rec := serve(); rec.Body.String() != "done" {
+				t.Fatalf("retry body = %q, want a fresh execution", rec.Body.String())
+			}
+			if executions.Load() != 2 {
+				t.Fatalf("executions = %d, want 2", executions.Load())
+			}
+		})
+	}
+}
+
+func TestIdempotencyHandlerDetachesContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := IdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		if r.Context().Err() != nil {
+			t.Errorf("handler context canceled with the client")
+		}
+		_, _ = io.WriteString(w, "done")
+	}), IdempotencyOptions{})
+
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/", strings.NewReader("{}"))
+	req.Header.Set(IdempotencyKeyHeader, "key-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestIdempotencyHandlerScopesKeysByPrincipal(t *testing.T) {
+	var executions atomic.Int32
+	handler := IdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprintf(w, "run %d", executions.Add(1))
+	}), IdempotencyOptions{})
+
+	send := func(keyID, token string) string {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		req.Header.Set(KeyIDHeader, keyID)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	if got := send("alice", "a"); got != "run 1" {
+		t.Fatalf("alice = %q", got)
+	}
+	if got := send("bob", "a"); got != "run 2" {
+		t.Fatalf("bob got another key ID's response: %q", got)
+	}
+	if got := send("alice", "b"); got != "run 3" {
+		t.Fatalf("other token got another caller's response: %q", got)
+	}
+	if got := send("alice", "a"); got != "run 1" {
+		t.Fatalf("alice replay = %q, want run 1", got)
+	}
+}
+
+func TestIdempotencyHandlerDoesNotRecordOversizedResponses(t *testing.T) {
+	var executions atomic.Int32
+	handler := IdempotencyHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		executions.Add(1)
+		_, _ = io.WriteString(w, "0123456789")
+		_, _ = io.WriteString(w, "0123456789")
+	}), IdempotencyOptions{MaxRecordBytes: 16})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Body.Len() != 20 {
+			t.Fatalf("response body = %d bytes, want 20", rec.Body.Len())
+		}
+	}
+	if executions.Load() != 2 {
+		t.Fatalf("executions = %d, want 2", executions.Load())
+	}
+}
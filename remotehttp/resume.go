@@ -0,0 +1,121 @@
+package remotehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// ExecutionIDHeader identifies a running execution on the remote runtime.
+//
+// Server contract: a server that supports resumable streams sets this header
+// on the SSE response to a streaming Execute request, and gives every event
+// an "id:" field that orders it within the execution. If the connection
+// drops before the terminal result or error event, the client issues
+//
+//	GET <endpoint>
+//	X-Toolruntime-Execution-Id: <execution id>
+//	Last-Event-ID: <last event id received>
+//	Accept: text/event-stream
+//
+// (signed like the original request, with an empty body) and the server
+// continues the same stream with the events after Last-Event-ID, without
+// running the code again. The execution keeps running while no client is
+// connected. A server that no longer knows the execution answers 404 or
+// 410, which fails the call. Servers that omit the header get the previous
+// behavior: a dropped stream fails the call. Servers may announce the ID
+// in an EventExecution event instead; the same ID is used to cancel the
+// execution.
+const ExecutionIDHeader = "X-Toolruntime-Execution-Id"
+
+// LastEventIDHeader is the standard SSE reconnection header.
+const LastEventIDHeader = "Last-Event-ID"
+
+// resumeStream reconnects an interrupted stream until it reaches a terminal
+// event. err is the outcome of the previous read. Up to MaxRetries
+// consecutive reconnects without new events are attempted.
+func (c *Client) resumeStream(ctx context.Context, call *call, state *streamState, err error) error {
+	attempt := 0
+	for !state.done && state.executionID != "" {
+		if errors.Is(err, ErrEventTooLarge) {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("%w: %w", remote.ErrRemoteExecutionFailed, ctxErr)
+		}
+		attempt++
+		if err == nil {
+			err = fmt.Errorf("%w: stream ended before result", remote.ErrConnectionFailed)
+		}
+		if attempt > c.maxRetries {
+			return err
+		}
+
+		delay := state.retry
+		if delay <= 0 {
+			var ok bool
+			delay, ok = c.retry.Backoff(attempt, fmt.Errorf("%w: stream interrupted", remote.ErrConnectionFailed))
+			if !ok {
+				return err
+			}
+		}
+		if c.logger != nil {
+			c.logger.Warn("remote stream resume", "execution_id", state.executionID, "last_event_id", state.lastEventID, "attempt", attempt, "delay", delay, "error", err)
+		}
+		if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+			return fmt.Errorf("%w: %w", remote.ErrRemoteExecutionFailed, sleepErr)
+		}
+
+		body, openErr := c.openResume(ctx, state)
+		if openErr != nil {
+			if !IsRetryable(openErr) {
+				return openErr
+			}
+			err = openErr
+			continue
+		}
+		before := state.events
+		err = c.readStream(body, call, state)
+		_ = body.Close()
+		if state.events > before {
+			attempt = 0
+		}
+	}
+	return err
+}
+
+func (c *Client) openResume(ctx context.Context, state *streamState) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, state.endpoint.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: build request: %v", remote.ErrConnectionFailed, err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set(ExecutionIDHeader, state.executionID)
+	if state.lastEventID != "" {
+		req.Header.Set(LastEventIDHeader, state.lastEventID)
+	}
+	resp, err := c.do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(body)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%w: resume response is not an event stream", remote.ErrRemoteExecutionFailed)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,157 @@
+package remotehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+type testStreamEvent struct {
+	name string
+	data string
+}
+
+// disconnectingServer serves a fixed execution stream, dropping every
+// connection after perConn events. Resume requests continue after the
+// Last-Event-ID they carry.
+type disconnectingServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	posts       int
+	resumes     int
+	lastEventID []string
+}
+
+func newDisconnectingServer(t *testing.T, executionID string, events []testStreamEvent, perConn int) *disconnectingServer {
+	t.Helper()
+	s := &disconnectingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := 0
+		s.mu.Lock()
+		switch r.Method {
+		case http.MethodPost:
+			s.posts++
+		case http.MethodGet:
+			s.resumes++
+			s.lastEventID = append(s.lastEventID, r.Header.Get(LastEventIDHeader))
+			if r.Header.Get(ExecutionIDHeader) != executionID {
+				s.mu.Unlock()
+				http.Error(w, "unknown execution", http.StatusGone)
+				return
+			}
+			start, _ = strconv.Atoi(r.Header.Get(LastEventIDHeader))
+		}
+		s.mu.Unlock()
+
+		if executionID != "" {
+			w.Header().Set(ExecutionIDHeader, executionID)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		end := min(start+perConn, len(events))
+		for i := start; i < end; i++ {
+			_, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", i+1, events[i].name, events[i].data)
+		}
+		w.(http.Flusher).Flush()
+		if end < len(events) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("hijack: %v", err)
+				return
+			}
+			_ = conn.Close()
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+var resumeTestEvents = []testStreamEvent{
+	{name: EventStdout, data: "a"},
+	{name: EventStdout, data: "b"},
+	{name: EventStderr, data: "c"},
+	{name: EventResult, data: `{"value":"done"}`},
+}
+
+func TestClientExecuteStreamResumesAfterDisconnect(t *testing.T) {
+	srv := newDisconnectingServer(t, "exec-1", resumeTestEvents, 2)
+	client, err := NewClient(Config{Endpoint: srv.URL, RetryPolicy: ExponentialBackoff{BaseDelay: 1}})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	var ids []string
+	resp, err := client.ExecuteStream(context.Background(), remote.RemoteRequest{}, func(event StreamEvent) {
+		ids = append(ids, event.ID)
+	})
+	if err != nil {
+		t.Fatalf("ExecuteStream error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Stdout != "ab" || resp.Result.Stderr != "c" || resp.Result.Value != "done" {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4]" {
+		t.Fatalf("event ids = %v", ids)
+	}
+	if srv.posts != 1 || srv.resumes != 1 || srv.lastEventID[0] != "2" {
+		t.Fatalf("posts = %d, resumes = %d, Last-Event-ID = %v", srv.posts, srv.resumes, srv.lastEventID)
+	}
+}
+
+func TestClientExecuteResumeProgressResetsAttempts(t *testing.T) {
+	srv := newDisconnectingServer(t, "exec-1", resumeTestEvents, 1)
+	client, err := NewClient(Config{Endpoint: srv.URL, MaxRetries: 1, RetryPolicy: ExponentialBackoff{BaseDelay: 1}})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	resp, err := client.Execute(context.Background(), remote.RemoteRequest{Stream: true})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Value != "done" {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+	if srv.posts != 1 || srv.resumes != 3 {
+		t.Fatalf("posts = %d, resumes = %d", srv.posts, srv.resumes)
+	}
+}
+
+func TestClientExecuteResumeGoneFails(t *testing.T) {
+	srv := newDisconnectingServer(t, "exec-1", resumeTestEvents, 2)
+	client, err := NewClient(Config{Endpoint: srv.URL, RetryPolicy: ExponentialBackoff{BaseDelay: 1}})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	// Resume an execution the server does not know.
+	state := &streamState{endpoint: client.pool.lastUsed(), executionID: "other", lastEventID: "2"}
+	err = client.resumeStream(context.Background(), &call{}, state, nil)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusGone {
+		t.Fatalf("expected 410 StatusError, got %v", err)
+	}
+	if srv.posts != 0 || srv.resumes != 1 {
+		t.Fatalf("posts = %d, resumes = %d", srv.posts, srv.resumes)
+	}
+}
+
+func TestClientExecuteStreamWithoutExecutionIDDoesNotRerun(t *testing.T) {
+	srv := newDisconnectingServer(t, "", resumeTestEvents, 2)
+	client, err := NewClient(Config{Endpoint: srv.URL, RetryPolicy: ExponentialBackoff{BaseDelay: 1}})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	_, err = client.Execute(context.Background(), remote.RemoteRequest{Stream: true})
+	if !errors.Is(err, remote.ErrRemoteExecutionFailed) {
+		t.Fatalf("expected ErrRemoteExecutionFailed, got %v", err)
+	}
+	if srv.posts != 1 || srv.resumes != 0 {
+		t.Fatalf("posts = %d, resumes = %d", srv.posts, srv.resumes)
+	}
+}
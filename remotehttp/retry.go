@@ -0,0 +1,141 @@
+package remotehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// RetryPolicy decides whether and when a failed attempt is retried.
+//
+// Contract:
+// - Concurrency: implementations must be safe for concurrent use.
+// - Backoff is called after attempt n (1-based) failed with err; it returns
+// the delay before the next attempt, or false to stop retrying.
+type RetryPolicy interface {
+	Backoff(attempt int, err error) (time.Duration, bool)
+}
+
+// ExponentialBackoff retries transient failures with exponentially growing,
+// jittered delays. A Retry-After hint from the server takes precedence.
+type ExponentialBackoff struct {
+	// BaseDelay is the delay before the first retry.
+	// Default: 100ms
+	BaseDelay time.Duration
+
+	// MaxDelay caps computed delays (Retry-After hints are not capped).
+	// Default: 5s
+	MaxDelay time.Duration
+
+	// Multiplier grows the delay between attempts.
+	// Default: 2
+	Multiplier float64
+
+	// Jitter is the fraction of each delay that is randomized, in [0, 1].
+	// Default: 0.5
+	Jitter float64
+}
+
+// Backoff implements RetryPolicy.
+func (b ExponentialBackoff) Backoff(attempt int, err error) (time.Duration, bool) {
+	if !IsRetryable(err) {
+		return 0, false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter, true
+	}
+
+	base := b.BaseDelay
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+	maxDelay := b.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Second
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	jitter := b.Jitter
+	if jitter <= 0 || jitter > 1 {
+		jitter = 0.5
+	}
+
+	delay := float64(base)
+	for i := 1; i < attempt && delay < float64(maxDelay); i++ {
+		delay *= multiplier
+	}
+	delay = min(delay, float64(maxDelay))
+	delay -= delay * jitter * rand.Float64() // #nosec G404 -- jitter does not need a CSPRNG
+	return time.Duration(delay), true
+}
+
+// StatusError reports a non-2xx response from the remote runtime.
+// It wraps remote.ErrRemoteExecutionFailed.
+type StatusError struct {
+	StatusCode int
+	Body       string
+
+	// RetryAfter is the server's Retry-After hint, if any.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	kind := "status"
+	if e.StatusCode >= 500 {
+		kind = "server error"
+	}
+	return fmt.Sprintf("%v: %s %d: %s", remote.ErrRemoteExecutionFailed, kind, e.StatusCode, e.Body)
+}
+
+func (e *StatusError) Unwrap() error {
+	return remote.ErrRemoteExecutionFailed
+}
+
+// IsRetryable reports whether err is a failure that is safe to retry:
+// connection errors and 429/502/503/504 responses. Context cancellation,
+// other 4xx/5xx responses and malformed responses are not retried.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, remote.ErrConnectionFailed)
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
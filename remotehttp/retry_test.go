@@ -0,0 +1,136 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+func TestClientExecuteDoesNotRetryClientErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusInternalServerError} {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(status)
+		}))
+
+		client, err := NewClient(Config{Endpoint: srv.URL, MaxRetries: 3})
+		if err != nil {
+			t.Fatalf("NewClient error: %v", err)
+		}
+		_, err = client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "return"}})
+		srv.Close()
+
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != status {
+			t.Fatalf("status %d: error = %v", status, err)
+		}
+		if !errors.Is(err, remote.ErrRemoteExecutionFailed) {
+			t.Fatalf("status %d: error does not wrap ErrRemoteExecutionFailed", status)
+		}
+		if got := calls.Load(); got != 1 {
+			t.Fatalf("status %d: calls = %d, want 1", status, got)
+		}
+	}
+}
+
+func TestClientExecuteHonorsRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	var first time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if elapsed := time.Since(first); elapsed < 900*time.Millisecond {
+			t.Errorf("retried after %v, want >= 1s", elapsed)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(remote.RemoteResponse{Result: &remote.ExecuteResultPayload{Stdout: "ok"}})
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{Endpoint: srv.URL, MaxRetries: 1})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	if _, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "return"}}); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("calls = %d, want 2", got)
+	}
+}
+
+type countingPolicy struct {
+	attempts atomic.Int32
+}
+
+func (p *countingPolicy) Backoff(attempt int, err error) (time.Duration, bool) {
+	p.attempts.Add(1)
+	return 0, IsRetryable(err)
+}
+
+func TestClientExecuteUsesConfiguredRetryPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	policy := &countingPolicy{}
+	client, err := NewClient(Config{Endpoint: srv.URL, MaxRetries: 2, RetryPolicy: policy})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	if _, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "return"}}); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := policy.attempts.Load(); got != 2 {
+		t.Fatalf("policy consulted %d times, want 2", got)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	policy := ExponentialBackoff{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.5}
+	connErr := remote.ErrConnectionFailed
+
+	for attempt := 1; attempt <= 6; attempt++ {
+		delay, ok := policy.Backoff(attempt, connErr)
+		if !ok {
+			t.Fatalf("attempt %d: expected retry", attempt)
+		}
+		ceiling := min(100*time.Millisecond<<(attempt-1), time.Second)
+		if delay > ceiling || delay < ceiling/2 {
+			t.Fatalf("attempt %d: delay %v outside [%v, %v]", attempt, delay, ceiling/2, ceiling)
+		}
+	}
+
+	if _, ok := policy.Backoff(1, context.Canceled); ok {
+		t.Fatal("context cancellation must not be retried")
+	}
+	if delay, ok := policy.Backoff(1, &StatusError{StatusCode: http.StatusServiceUnavailable, RetryAfter: 7 * time.Second}); !ok || delay != 7*time.Second {
+		t.Fatalf("Retry-After not honored: %v %v", delay, ok)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if got := parseRetryAfter("3", now); got != 3*time.Second {
+		t.Fatalf("seconds = %v", got)
+	}
+	if got := parseRetryAfter(now.Add(10*time.Second).Format(http.TimeFormat), now); got != 10*time.Second {
+		t.Fatalf("http date = %v", got)
+	}
+	if got := parseRetryAfter("soon", now); got != 0 {
+		t.Fatalf("invalid = %v", got)
+	}
+}
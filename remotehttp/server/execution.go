@@ -0,0 +1,297 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonwraymond/toolexec-integrations/remotehttp"
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// errEventsDropped is returned by since when events after the requested ID
+// have already been dropped from the event log.
+var errEventsDropped = errors.New("events before the retained window were dropped")
+
+type streamEvent struct {
+	id   int
+	name string
+	data string
+}
+
+// execution is the event log of one streamed or async execution. Readers follow it
+// with since; every append wakes them by closing changed. The log keeps the
+// last maxEvents events; dropped counts the ones before them, so event IDs
+// stay stable.
+type execution struct {
+	id        string
+	cancel    context.CancelFunc
+	maxEvents int
+
+	mu       sync.Mutex
+	events   []streamEvent
+	dropped  int
+	done     bool
+	code     string
+	finished time.Time
+	changed  chan struct{}
+	nextTool int
+	tools    map[string]chan remotehttp.ToolResponse
+}
+
+func newExecution(id string, cancel context.CancelFunc, maxEvents int) *execution {
+	return &execution{
+		id:        id,
+		cancel:    cancel,
+		maxEvents: maxEvents,
+		changed:   make(chan struct{}),
+		tools:     map[string]chan remotehttp.ToolResponse{},
+	}
+}
+
+func (e *execution) append(name, data string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return
+	}
+	e.appendLocked(name, data)
+}
+
+func (e *execution) appendLocked(name, data string) {
+	e.events = append(e.events, streamEvent{id: e.dropped + len(e.events) + 1, name: name, data: data})
+	if e.maxEvents > 0 && len(e.events) > e.maxEvents {
+		// Reslice rather than shift: readers may still hold the old
+		// slice, and the next growth copies only the retained events.
+		n := len(e.events) - e.maxEvents
+		e.events = e.events[n:]
+		e.dropped += n
+	}
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// finish appends the terminal event.
+func (e *execution) finish(name, data string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return
+	}
+	e.appendLocked(name, data)
+	e.done = true
+	e.finished = time.Now()
+}
+
+// fail appends a terminal error event with a machine-readable code.
+func (e *execution) fail(code, message string) {
+	e.mu.Lock()
+	if !e.done {
+		e.code = code
+	}
+	e.mu.Unlock()
+	e.finish(remotehttp.EventError, message)
+}
+
+// wait blocks until the execution finishes, ctx ends or d elapses, and
+// reports whether it finished.
+func (e *execution) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		_, done, changed, _ := e.since(math.MaxInt)
+		if done {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// response assembles the JSON response of a finished execution from its
+// event log. Output dropped from the log is missing unless the executor
+// returned it in the result.
+func (e *execution) response() remote.RemoteResponse {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var stdout, stderr strings.Builder
+	for _, event := range e.events {
+		switch event.name {
+		case remotehttp.EventStdout:
+			stdout.WriteString(event.data)
+		case remotehttp.EventStderr:
+			stderr.WriteString(event.data)
+		case remotehttp.EventError:
+			return remote.RemoteResponse{Error: &remote.RemoteError{Code: e.code, Message: event.data}}
+		case remotehttp.EventResult:
+			var result remote.ExecuteResultPayload
+			if err := json.Unmarshal([]byte(event.data), &result); err != nil {
+				return remote.RemoteResponse{Error: &remote.RemoteError{Code: "execution_failed", Message: "decode result: " + err.Error()}}
+			}
+			if result.Stdout == "" {
+				result.Stdout = stdout.String()
+			}
+			if result.Stderr == "" {
+				result.Stderr = stderr.String()
+			}
+			return remote.RemoteResponse{Result: &result}
+		}
+	}
+	return remote.RemoteResponse{}
+}
+
+// since returns the events after ID after, whether they include the
+// terminal event, and a channel closed on the next change. It returns
+// errEventsDropped if some of those events are no longer retained.
+func (e *execution) since(after int) ([]streamEvent, bool, <-chan struct{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if after < e.dropped {
+		return nil, e.done, e.changed, errEventsDropped
+	}
+	after = min(after-e.dropped, len(e.events))
+	return e.events[after:len(e.events):len(e.events)], e.done, e.changed, nil
+}
+
+func (e *execution) finishedAt() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.finished
+}
+
+func (e *execution) registerTool() (string, chan remotehttp.ToolResponse) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextTool++
+	id := "t" + strconv.Itoa(e.nextTool)
+	ch := make(chan remotehttp.ToolResponse, 1)
+	e.tools[id] = ch
+	return id, ch
+}
+
+func (e *execution) unregisterTool(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.tools, id)
+}
+
+func (e *execution) deliverTool(response remotehttp.ToolResponse) bool {
+	e.mu.Lock()
+	ch, ok := e.tools[response.RequestID]
+	delete(e.tools, response.RequestID)
+	e.mu.Unlock()
+	if ok {
+		ch <- response
+	}
+	return ok
+}
+
+// streamOutput appends output to a streamed execution.
+type streamOutput struct {
+	exec *execution
+}
+
+func (o *streamOutput) Stdout(text string) { o.exec.append(remotehttp.EventStdout, text) }
+
+func (o *streamOutput) Stderr(text string) { o.exec.append(remotehttp.EventStderr, text) }
+
+func (o *streamOutput) Emit(event string, data any) {
+	o.exec.append(event, encodeData(data))
+}
+
+func (o *streamOutput) CallTool(ctx context.Context, toolID string, args any) (json.RawMessage, error) {
+	rawArgs, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	id, ch := o.exec.registerTool()
+	defer o.exec.unregisterTool(id)
+
+	req := remotehttp.ToolRequest{RequestID: id, ToolID: toolID, Args: rawArgs}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.TimeoutMs = max(time.Until(deadline).Milliseconds(), 1)
+	}
+	o.Emit(remotehttp.EventToolRequest, req)
+
+	select {
+	case response := <-ch:
+		if response.Error != "" {
+			return nil, &ToolError{ToolID: toolID, Message: response.Error}
+		}
+		return response.Result, nil
+	case <-ctx.Done():
+		o.Emit(remotehttp.EventToolCancel, remotehttp.ToolRequest{RequestID: id})
+		return nil, ctx.Err()
+	}
+}
+
+// bufferOutput collects output for a JSON response.
+type bufferOutput struct {
+	mu     sync.Mutex
+	stdout strings.Builder
+	stderr strings.Builder
+}
+
+func (o *bufferOutput) Stdout(text string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stdout.WriteString(text)
+}
+
+func (o *bufferOutput) Stderr(text string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stderr.WriteString(text)
+}
+
+func (o *bufferOutput) Emit(string, any) {}
+
+func (o *bufferOutput) CallTool(context.Context, string, any) (json.RawMessage, error) {
+	return nil, ErrToolCallsUnsupported
+}
+
+func (o *bufferOutput) response(result remote.ExecuteResultPayload, err error) remote.RemoteResponse {
+	if err != nil {
+		return remote.RemoteResponse{Error: &remote.RemoteError{Code: errorCode(err), Message: err.Error()}}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if result.Stdout == "" {
+		result.Stdout = o.stdout.String()
+	}
+	if result.Stderr == "" {
+		result.Stderr = o.stderr.String()
+	}
+	return remote.RemoteResponse{Result: &result}
+}
+
+// errorCode returns the code of an *Error in err's chain, or
+// "execution_failed".
+func errorCode(err error) string {
+	var coded *Error
+	if errors.As(err, &coded) && coded.Code != "" {
+		return coded.Code
+	}
+	return "execution_failed"
+}
+
+func encodeData(data any) string {
+	if s, ok := data.(string); ok {
+		return s
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
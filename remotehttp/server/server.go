@@ -0,0 +1,520 @@
+// Package server provides a reference implementation of the remote runtime
+// side of the remotehttp wire protocol. It decodes requests, checks
+// authentication, dispatches to an Executor and writes JSON or SSE
+// responses, including resumable streams, async jobs and tool callbacks.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonwraymond/toolexec-integrations/internal/compression"
+	"github.com/jonwraymond/toolexec-integrations/remotehttp"
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// ErrExecutorRequired is returned by NewHandler when Config.Executor is nil.
+var ErrExecutorRequired = errors.New("server: executor is required")
+
+// ErrToolCallsUnsupported is returned by Output.CallTool for requests that
+// are not streamed, since tool requests travel as stream events.
+var ErrToolCallsUnsupported = errors.New("server: tool calls require a streaming request")
+
+// Executor runs code for the remote runtime.
+//
+// Contract:
+// - Concurrency: Execute is called concurrently for concurrent requests.
+// - ctx is canceled when the execution should stop. For streamed requests
+// it is detached from the client connection, so a client can reconnect,
+// and is canceled when the client sends a cancel request instead.
+// - Output written to out is delivered live on streams. Returning an error
+// reports it to the client; use *Error to set the error code.
+// - When the result leaves Stdout or Stderr empty, the output written to
+// out is used.
+type Executor interface {
+	Execute(ctx context.Context, req remote.RemoteRequest, out Output) (remote.ExecuteResultPayload, error)
+}
+
+// ExecutorFunc adapts a function to Executor.
+type ExecutorFunc func(ctx context.Context, req remote.RemoteRequest, out Output) (remote.ExecuteResultPayload, error)
+
+// Execute implements Executor.
+func (f ExecutorFunc) Execute(ctx context.Context, req remote.RemoteRequest, out Output) (remote.ExecuteResultPayload, error) {
+	return f(ctx, req, out)
+}
+
+// Output receives an execution's live output. It is safe for concurrent use.
+type Output interface {
+	// Stdout and Stderr write program output.
+	Stdout(text string)
+	Stderr(text string)
+
+	// Emit sends another event, such as progress, toolcall or
+	// toolcall_result. data is sent as is if it is a string and JSON
+	// encoded otherwise. Events are dropped for JSON responses.
+	Emit(event string, data any)
+
+	// CallTool asks the client to run one of its host tools and waits for
+	// the result. Canceling ctx withdraws the request.
+	CallTool(ctx context.Context, toolID string, args any) (json.RawMessage, error)
+}
+
+// Error is an execution error with a machine-readable code.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ToolError is returned by CallTool when the client reports a tool failure.
+type ToolError struct {
+	ToolID  string
+	Message string
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("tool %s: %s", e.ToolID, e.Message)
+}
+
+// Config configures the handler.
+type Config struct {
+	// Executor runs requests. Required.
+	Executor Executor
+
+	// AuthToken, when set, requires requests to carry
+	// "Authorization: Bearer <AuthToken>".
+	AuthToken string
+
+	// Verifier, when set, requires v2 request signatures.
+	Verifier *remotehttp.VerifierOptions
+
+	// Idempotency, when set, dedupes execute requests that carry an
+	// Idempotency-Key. See remotehttp.IdempotencyHandler.
+	Idempotency *remotehttp.IdempotencyOptions
+
+	// MaxRequestBytes caps request bodies.
+	// Default: 32MiB
+	MaxRequestBytes int64
+
+	// RetainFor keeps the events of finished streamed and async executions
+	// so clients can resume after a disconnect or fetch the result.
+	// Default: 5m
+	RetainFor time.Duration
+
+	// MaxEvents caps the events kept per streamed or async execution. The
+	// oldest events are dropped first; resuming from before the kept ones
+	// is answered with 410 Gone.
+	// Default: 10000
+	MaxEvents int
+
+	// Languages and MaxTimeout are advertised in the capabilities document.
+	// The executor is responsible for enforcing them.
+	Languages  []string
+	MaxTimeout time.Duration
+
+	// MaxPollWait caps how long an async status poll is held open.
+	// Default: 30s
+	MaxPollWait time.Duration
+
+	// Logger is an optional logger for server events.
+	Logger remote.Logger
+}
+
+// Handler serves the remote runtime protocol. Mount it at
+// remotehttp.WellKnownPath as well as at the endpoint path so clients can
+// discover its capabilities.
+type Handler struct {
+	executor    Executor
+	caps        remotehttp.Capabilities
+	authToken   string
+	maxBody     int64
+	retainFor   time.Duration
+	maxEvents   int
+	maxPollWait time.Duration
+	logger      remote.Logger
+	handler     http.Handler
+
+	mu         sync.Mutex
+	executions map[string]*execution
+	closed     bool
+	running    sync.WaitGroup
+}
+
+// NewHandler creates a handler from cfg.
+func NewHandler(cfg Config) (*Handler, error) {
+	if cfg.Executor == nil {
+		return nil, ErrExecutorRequired
+	}
+	maxBody := cfg.MaxRequestBytes
+	if maxBody <= 0 {
+		maxBody = 32 << 20
+	}
+	retainFor := cfg.RetainFor
+	if retainFor <= 0 {
+		retainFor = 5 * time.Minute
+	}
+	maxEvents := cfg.MaxEvents
+	if maxEvents <= 0 {
+		maxEvents = 10000
+	}
+	maxPollWait := cfg.MaxPollWait
+	if maxPollWait <= 0 {
+		maxPollWait = 30 * time.Second
+	}
+	h := &Handler{
+		executor: cfg.Executor,
+		caps: remotehttp.Capabilities{
+			ProtocolVersion: remotehttp.ProtocolVersion,
+			Languages:       slices.Clone(cfg.Languages),
+			MaxTimeoutMs:    cfg.MaxTimeout.Milliseconds(),
+			Streaming:       true,
+			ToolCalls:       true,
+			Resume:          true,
+			Async:           true,
+			Cancel:          true,
+			Compression:     slices.Clone(compression.Supported),
+		},
+		authToken:   cfg.AuthToken,
+		maxBody:     maxBody,
+		retainFor:   retainFor,
+		maxEvents:   maxEvents,
+		maxPollWait: maxPollWait,
+		logger:      cfg.Logger,
+		executions:  map[string]*execution{},
+	}
+
+	var handler http.Handler = http.HandlerFunc(h.route)
+	if cfg.Idempotency != nil {
+		handler = remotehttp.IdempotencyHandler(handler, *cfg.Idempotency)
+	}
+	if cfg.Verifier != nil {
+		handler = remotehttp.Verifier(handler, *cfg.Verifier)
+	}
+	h.handler = handler
+	return h, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.authToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.authToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBody)
+	h.handler.ServeHTTP(w, r)
+}
+
+// Close cancels all running executions and waits for their executors to
+// return. Streamed and async executions started afterwards fail at once.
+func (h *Handler) Close() {
+	h.mu.Lock()
+	h.closed = true
+	for _, exec := range h.executions {
+		exec.cancel()
+	}
+	h.mu.Unlock()
+	h.running.Wait()
+}
+
+func (h *Handler) route(w http.ResponseWriter, r *http.Request) {
+	if coding := r.Header.Get("Content-Encoding"); coding != "" {
+		body, err := compression.NewReader(coding, r.Body)
+		if errors.Is(err, compression.ErrUnsupported) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(w, "decode request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Signatures and idempotency checks above cover the compressed
+		// bytes; the limit here caps the decoded size.
+		r.Body = http.MaxBytesReader(w, body, h.maxBody)
+		r.Header.Del("Content-Encoding")
+	}
+
+	executionID := r.Header.Get(remotehttp.ExecutionIDHeader)
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, remotehttp.WellKnownPath):
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(h.caps)
+	case r.Method == http.MethodPost && executionID == "":
+		h.serveExecute(w, r)
+	case r.Method == http.MethodPost:
+		h.serveToolResponse(w, r, executionID)
+	case r.Method == http.MethodGet && executionID != "":
+		h.serveResume(w, r, executionID)
+	case r.Method == http.MethodGet && r.URL.Query().Has(statusQuery):
+		h.serveStatus(w, r, r.URL.Query().Get(statusQuery))
+	case r.Method == http.MethodDelete && executionID != "":
+		h.serveCancel(w, executionID)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) serveExecute(w http.ResponseWriter, r *http.Request) {
+	var req remote.RemoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "decode request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.logger != nil {
+		h.logger.Info("remote execution received", "stream", req.Stream, "language", req.Request.Language)
+	}
+
+	if !req.Stream && preference(r, "respond-async") != "" {
+		exec := h.start(r.Context(), req)
+		w.Header().Set(remotehttp.ExecutionIDHeader, exec.id)
+		w.Header().Set("Location", "?"+url.Values{statusQuery: {exec.id}}.Encode())
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if !req.Stream {
+		out := &bufferOutput{}
+		result, err := h.executor.Execute(r.Context(), req, out)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out.response(result, err))
+		return
+	}
+
+	exec := h.start(r.Context(), req)
+	w.Header().Set(remotehttp.ExecutionIDHeader, exec.id)
+	h.stream(w, r, exec, 0)
+}
+
+func (h *Handler) serveResume(w http.ResponseWriter, r *http.Request, executionID string) {
+	exec := h.lookup(executionID)
+	if exec == nil {
+		http.Error(w, "unknown execution", http.StatusGone)
+		return
+	}
+	after := 0
+	if last := r.Header.Get(remotehttp.LastEventIDHeader); last != "" {
+		n, err := strconv.Atoi(last)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+	w.Header().Set(remotehttp.ExecutionIDHeader, exec.id)
+	h.stream(w, r, exec, after)
+}
+
+func (h *Handler) serveCancel(w http.ResponseWriter, executionID string) {
+	exec := h.lookup(executionID)
+	if exec == nil {
+		http.Error(w, "unknown execution", http.StatusGone)
+		return
+	}
+	if h.logger != nil {
+		h.logger.Info("remote execution cancel requested", "execution_id", exec.id)
+	}
+	exec.cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveStatus answers an async status poll, holding it open for up to the
+// requested wait while the execution runs.
+func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request, executionID string) {
+	exec := h.lookup(executionID)
+	if exec == nil {
+		http.Error(w, "unknown execution", http.StatusGone)
+		return
+	}
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(preference(r, "wait")); err == nil && seconds > 0 {
+		wait = min(time.Duration(seconds)*time.Second, h.maxPollWait)
+	}
+	if !exec.wait(r.Context(), wait) {
+		w.Header().Set(remotehttp.ExecutionIDHeader, exec.id)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(exec.response())
+}
+
+func (h *Handler) serveToolResponse(w http.ResponseWriter, r *http.Request, executionID string) {
+	exec := h.lookup(executionID)
+	if exec == nil {
+		http.Error(w, "unknown execution", http.StatusGone)
+		return
+	}
+	var response remotehttp.ToolResponse
+	if err := json.NewDecoder(r.Body).Decode(&response); err != nil {
+		http.Error(w, "decode tool response: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !exec.deliverTool(response) {
+		http.Error(w, "no pending tool request", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// start registers a streamed or async execution and runs it in the
+// background.
+func (h *Handler) start(parent context.Context, req remote.RemoteRequest) *execution {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	exec := newExecution(newExecutionID(), cancel, h.maxEvents)
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		cancel()
+		exec.fail("canceled", "server is closing")
+		return exec
+	}
+	h.sweep(time.Now())
+	h.executions[exec.id] = exec
+	h.running.Add(1)
+	h.mu.Unlock()
+
+	go func() {
+		defer h.running.Done()
+		defer cancel()
+		out := &streamOutput{exec: exec}
+		result, err := h.executor.Execute(ctx, req, out)
+		if err != nil {
+			if h.logger != nil {
+				h.logger.Warn("remote execution failed", "execution_id", exec.id, "error", err)
+			}
+			if ctx.Err() != nil {
+				exec.fail("canceled", "execution canceled")
+				return
+			}
+			exec.fail(errorCode(err), err.Error())
+			return
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			exec.fail("execution_failed", "encode result: "+err.Error())
+			return
+		}
+		exec.finish(remotehttp.EventResult, string(data))
+	}()
+	return exec
+}
+
+func (h *Handler) lookup(id string) *execution {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.executions[id]
+}
+
+// sweep forgets executions that finished more than RetainFor ago. Callers
+// hold h.mu.
+func (h *Handler) sweep(now time.Time) {
+	for id, exec := range h.executions {
+		if finished := exec.finishedAt(); !finished.IsZero() && now.Sub(finished) > h.retainFor {
+			delete(h.executions, id)
+		}
+	}
+}
+
+// stream writes the events of exec after event ID after until the
+// execution finishes or the client goes away. A resume from before the
+// retained events is answered with 410 Gone; a reader that falls that far
+// behind mid-stream is disconnected, and its resume gets the same answer.
+func (h *Handler) stream(w http.ResponseWriter, r *http.Request, exec *execution, after int) {
+	events, done, changed, err := exec.since(after)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		for _, event := range events {
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			after = event.id
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		if events, done, changed, err = exec.since(after); err != nil {
+			if h.logger != nil {
+				h.logger.Warn("remote stream reader fell behind", "execution_id", exec.id, "last_event_id", after)
+			}
+			return
+		}
+	}
+}
+
+// writeEvent writes one SSE event. Line breaks in data become separate
+// data lines; a lone CR cannot be represented and is sent as LF.
+func writeEvent(w io.Writer, event streamEvent) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "id: %d\nevent: %s\n", event.id, event.name)
+	data := strings.ReplaceAll(strings.ReplaceAll(event.data, "\r\n", "\n"), "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// statusQuery is the query parameter of async status URLs.
+const statusQuery = "execution_id"
+
+// preference returns the value of a Prefer header token (RFC 7240), or the
+// token itself if it has no value. It returns "" if the token is absent.
+func preference(r *http.Request, name string) string {
+	for _, header := range r.Header.Values(remotehttp.PreferHeader) {
+		for _, token := range strings.Split(header, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(token), "=")
+			if strings.EqualFold(strings.TrimSpace(key), name) {
+				if value = strings.Trim(strings.TrimSpace(value), `"`); value != "" {
+					return value
+				}
+				return key
+			}
+		}
+	}
+	return ""
+}
+
+func newExecutionID() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
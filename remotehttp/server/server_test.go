@@ -0,0 +1,408 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonwraymond/toolexec-integrations/remotehttp"
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+func newTestServer(t *testing.T, cfg Config) *httptest.Server {
+	t.Helper()
+	handler, err := NewHandler(cfg)
+	if err != nil {
+		t.Fatalf("NewHandler error: %v", err)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		srv.Close()
+		handler.Close()
+	})
+	return srv
+}
+
+var echoExecutor = ExecutorFunc(func(_ context.Context, req remote.RemoteRequest, out Output) (remote.ExecuteResultPayload, error) {
+	if req.Request.Code == "fail" {
+		return remote.ExecuteResultPayload{}, &Error{Code: "bad_code", Message: "cannot run"}
+	}
+	out.Stdout("line 1\n")
+	out.Emit(remotehttp.EventProgress, map[string]int{"pct": 100})
+	out.Stderr("  indented\n")
+	return remote.ExecuteResultPayload{Value: req.Request.Code}, nil
+})
+
+func TestHandlerJSON(t *testing.T) {
+	srv := newTestServer(t, Config{Executor: echoExecutor, AuthToken: "token"})
+	client, err := remotehttp.NewClient(remotehttp.Config{Endpoint: srv.URL, AuthToken: "token"})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	resp, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "x"}})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Value != "x" || resp.Result.Stdout != "line 1\n" || resp.Result.Stderr != "  indented\n" {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+
+	resp, err = client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "fail"}})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Error == nil || resp.Error.Code != "bad_code" || resp.Error.Message != "cannot run" {
+		t.Fatalf("unexpected error: %#v", resp.Error)
+	}
+}
+
+func TestHandlerStream(t *testing.T) {
+	srv := newTestServer(t, Config{Executor: echoExecutor})
+	client, err := remotehttp.NewClient(remotehttp.Config{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	var names []string
+	resp, err := client.ExecuteStream(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "x"}}, func(event remotehttp.StreamEvent) {
+		names = append(names, event.Name)
+	})
+	if err != nil {
+		t.Fatalf("ExecuteStream error: %v", err)
+	}
+	if strings.Join(names, ",") != "stdout,progress,stderr,result" {
+		t.Fatalf("events = %v", names)
+	}
+	if resp.Result.Stdout != "line 1\n" || resp.Result.Stderr != "  indented\n" || resp.Result.Value != "x" {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+
+	_, err = client.ExecuteStream(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "fail"}}, nil)
+	if !errors.Is(err, remote.ErrRemoteExecutionFailed) || !strings.Contains(err.Error(), "cannot run") {
+		t.Fatalf("expected execution error, got %v", err)
+	}
+}
+
+func TestHandlerResume(t *testing.T) {
+	srv := newTestServer(t, Config{Executor: echoExecutor})
+
+	body := `{"request":{"code":"x"},"stream":true}`
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	executionID := resp.Header.Get(remotehttp.ExecutionIDHeader)
+	if executionID == "" {
+		t.Fatal("missing execution ID")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set(remotehttp.ExecutionIDHeader, executionID)
+	req.Header.Set(remotehttp.LastEventIDHeader, "2")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if strings.Contains(string(data), "line 1") || !strings.Contains(string(data), "id: 3\nevent: stderr\ndata:   indented\ndata: \n") {
+		t.Fatalf("resumed stream = %q", data)
+	}
+
+	req.Header.Set(remotehttp.ExecutionIDHeader, "unknown")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("unknown execution status = %d, want 410", resp.StatusCode)
+	}
+}
+
+func TestHandlerBoundsEventLog(t *testing.T) {
+	srv := newTestServer(t, Config{Executor: echoExecutor, MaxEvents: 2})
+
+	body := `{"request":{"code":"x"},"stream":true}`
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	executionID := resp.Header.Get(remotehttp.ExecutionIDHeader)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set(remotehttp.ExecutionIDHeader, executionID)
+	req.Header.Set(remotehttp.LastEventIDHeader, "2")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if !strings.Contains(string(data), "id: 3\nevent: stderr\n") || !strings.Contains(string(data), "id: 4\nevent: result\n") {
+		t.Fatalf("resumed stream = %q", data)
+	}
+
+	req.Header.Set(remotehttp.LastEventIDHeader, "1")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("resume before retained events status = %d, want 410", resp.StatusCode)
+	}
+}
+
+func TestHandlerCloseWaitsForExecutions(t *testing.T) {
+	started := make(chan struct{})
+	var returned atomic.Bool
+	handler, err := NewHandler(Config{Executor: ExecutorFunc(func(ctx context.Context, _ remote.RemoteRequest, _ Output) (remote.ExecuteResultPayload, error) {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		returned.Store(true)
+		return remote.ExecuteResultPayload{}, ctx.Err()
+	})})
+	if err != nil {
+		t.Fatalf("NewHandler error: %v", err)
+	}
+
+	handler.start(context.Background(), remote.RemoteRequest{})
+	<-started
+	handler.Close()
+	if !returned.Load() {
+		t.Fatal("Close returned before the executor")
+	}
+
+	exec := handler.start(context.Background(), remote.RemoteRequest{})
+	if _, done, _, _ := exec.since(0); !done || exec.response().Error == nil {
+		t.Fatalf("execution started after Close did not fail: %#v", exec.response())
+	}
+}
+
+func TestHandlerToolCallback(t *testing.T) {
+	srv := newTestServer(t, Config{Executor: ExecutorFunc(func(ctx context.Context, _ remote.RemoteRequest, out Output) (remote.ExecuteResultPayload, error) {
+		raw, err := out.CallTool(ctx, "double", map[string]int{"n": 21})
+		if err != nil {
+			return remote.ExecuteResultPayload{}, err
+		}
+		var n int
+		_ = json.Unmarshal(raw, &n)
+		if _, err := out.CallTool(ctx, "missing", nil); err == nil {
+			return remote.ExecuteResultPayload{}, errors.New("expected tool error")
+		}
+		return remote.ExecuteResultPayload{Value: float64(n)}, nil
+	})})
+
+	client, err := remotehttp.NewClient(remotehttp.Config{
+		Endpoint: srv.URL,
+		ToolHandlers: map[string]remotehttp.ToolHandler{
+			"double": func(_ context.Context, args json.RawMessage) (any, error) {
+				var in struct{ N int }
+				_ = json.Unmarshal(args, &in)
+				return in.N * 2, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	resp, err := client.Execute(context.Background(), remote.RemoteRequest{})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Value != float64(42) {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+}
+
+func TestHandlerCancel(t *testing.T) {
+	stopped := make(chan error, 1)
+	srv := newTestServer(t, Config{Executor: ExecutorFunc(func(ctx context.Context, _ remote.RemoteRequest, out Output) (remote.ExecuteResultPayload, error) {
+		out.Stdout("started\n")
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return remote.ExecuteResultPayload{}, ctx.Err()
+	})})
+	client, err := remotehttp.NewClient(remotehttp.Config{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = client.ExecuteStream(ctx, remote.RemoteRequest{}, func(event remotehttp.StreamEvent) {
+		if event.Name == remotehttp.EventStdout {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("executor ctx error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("execution was not canceled")
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL, nil)
+	req.Header.Set(remotehttp.ExecutionIDHeader, "unknown")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE error: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("unknown execution status = %d, want 410", resp.StatusCode)
+	}
+}
+
+func TestHandlerAsync(t *testing.T) {
+	srv := newTestServer(t, Config{Executor: echoExecutor})
+	client, err := remotehttp.NewClient(remotehttp.Config{Endpoint: srv.URL, Async: true, PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	resp, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "x"}})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Value != "x" || resp.Result.Stdout != "line 1\n" || resp.Result.Stderr != "  indented\n" {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+
+	resp, err = client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "fail"}})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Error == nil || resp.Error.Code != "bad_code" || resp.Error.Message != "cannot run" {
+		t.Fatalf("unexpected error: %#v", resp.Error)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"?execution_id=unknown", nil)
+	httpResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	_ = httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusGone {
+		t.Fatalf("unknown execution status = %d, want 410", httpResp.StatusCode)
+	}
+}
+
+func TestHandlerCapabilities(t *testing.T) {
+	handler, err := NewHandler(Config{Executor: echoExecutor, AuthToken: "token", Languages: []string{"python"}})
+	if err != nil {
+		t.Fatalf("NewHandler error: %v", err)
+	}
+	defer handler.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/v1/execute", handler)
+	mux.Handle(remotehttp.WellKnownPath, handler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client, err := remotehttp.NewClient(remotehttp.Config{Endpoint: srv.URL + "/v1/execute", AuthToken: "token", CheckCapabilities: true})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+	caps, err := client.Capabilities(context.Background())
+	if err != nil {
+		t.Fatalf("Capabilities error: %v", err)
+	}
+	if caps.ProtocolVersion != remotehttp.ProtocolVersion || !caps.Streaming || !caps.ToolCalls || len(caps.Languages) != 1 {
+		t.Fatalf("unexpected capabilities: %#v", caps)
+	}
+
+	if _, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Language: "ruby"}}); !errors.Is(err, remotehttp.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	if _, err := client.ExecuteStream(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Language: "python"}}, nil); err != nil {
+		t.Fatalf("ExecuteStream error: %v", err)
+	}
+}
+
+func TestHandlerCompressedRequest(t *testing.T) {
+	srv := newTestServer(t, Config{Executor: echoExecutor, AuthToken: "token", Verifier: &remotehttp.VerifierOptions{Keys: map[string][]byte{"": []byte("secret")}}})
+	client, err := remotehttp.NewClient(remotehttp.Config{
+		Endpoint:             srv.URL,
+		AuthToken:            "token",
+		SigningKey:           []byte("secret"),
+		Compression:          remotehttp.CompressionZstd,
+		CompressionThreshold: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	resp, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "x"}})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Value != "x" {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+
+	plain := newTestServer(t, Config{Executor: echoExecutor})
+	req, _ := http.NewRequest(http.MethodPost, plain.URL, strings.NewReader("{}"))
+	req.Header.Set("Content-Encoding", "br")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("unknown encoding status = %d, want 415", res.StatusCode)
+	}
+}
+
+func TestHandlerAuthentication(t *testing.T) {
+	keys := map[string][]byte{"k1": []byte("signing-key")}
+	srv := newTestServer(t, Config{
+		Executor:  echoExecutor,
+		AuthToken: "token",
+		Verifier:  &remotehttp.VerifierOptions{Keys: keys},
+	})
+
+	for _, cfg := range []remotehttp.Config{
+		{Endpoint: srv.URL},
+		{Endpoint: srv.URL, AuthToken: "token"},
+		{Endpoint: srv.URL, AuthToken: "token", SigningKey: []byte("wrong"), SigningKeyID: "k1"},
+	} {
+		client, err := remotehttp.NewClient(cfg)
+		if err != nil {
+			t.Fatalf("NewClient error: %v", err)
+		}
+		_, err = client.Execute(context.Background(), remote.RemoteRequest{})
+		var statusErr *remotehttp.StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %v", err)
+		}
+	}
+
+	client, err := remotehttp.NewClient(remotehttp.Config{Endpoint: srv.URL, AuthToken: "token", SigningKey: keys["k1"], SigningKeyID: "k1"})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	if _, err := client.ExecuteStream(context.Background(), remote.RemoteRequest{}, nil); err != nil {
+		t.Fatalf("ExecuteStream error: %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package remotehttp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// Request signing headers.
+//
+// v2 scheme: the client sets TimestampHeader (RFC 3339, UTC), a random
+// NonceHeader, KeyIDHeader (Config.SigningKeyID) and
+//
+//	X-Toolruntime-Signature: v2=<base64 HMAC-SHA256(SigningKey, canonical)>
+//
+// where canonical is the newline-joined list
+//
+//	v2, METHOD, escaped path[?raw query], timestamp, nonce, key id,
+//	X-Toolruntime-Execution-Id, Last-Event-ID, Idempotency-Key,
+//	Content-Encoding, hex SHA-256 of the body
+//
+// Header values are empty when the header is absent. Binding them keeps a
+// captured cancel, resume or tool response from being redirected to
+// another execution.
+//
+// The path is the one the client sent; proxies that rewrite paths must be
+// configured to preserve it. Verifier implements the server side.
+//
+// The legacy v1 scheme signs timestamp + "." + body with the bearer token
+// and sends the bare base64 HMAC; it is used when no SigningKey is set.
+const (
+	SignatureHeader = "X-Toolruntime-Signature"
+	TimestampHeader = "X-Toolruntime-Timestamp"
+	NonceHeader     = "X-Toolruntime-Nonce"
+	KeyIDHeader     = "X-Toolruntime-Key-Id"
+
+	signatureV2Prefix = "v2="
+)
+
+// ErrSignatureInvalid is returned by RequestVerifier.Verify when a request is unsigned,
+// signed with an unknown key, stale, replayed, or does not match.
+var ErrSignatureInvalid = errors.New("request signature invalid")
+
+// authorize sets the Authorization header for token and signs req.
+func (c *Client) authorize(req *http.Request, payload []byte, token string) error {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if len(c.signingKey) > 0 {
+		if err := signRequestV2(req, payload, c.signingKeyID, c.signingKey, time.Now()); err != nil {
+			return fmt.Errorf("%w: sign request: %v", remote.ErrRemoteExecutionFailed, err)
+		}
+		return nil
+	}
+	if token != "" {
+		signRequest(req, payload, token)
+	}
+	return nil
+}
+
+func signRequest(req *http.Request, payload []byte, token string) {
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	mac := hmac.New(sha256.New, []byte(token))
+	_, _ = mac.Write([]byte(timestamp))
+	_, _ = mac.Write([]byte("."))
+	_, _ = mac.Write(payload)
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, signature)
+}
+
+func signRequestV2(req *http.Request, payload []byte, keyID string, key []byte, now time.Time) error {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return err
+	}
+	timestamp := now.UTC().Format(time.RFC3339Nano)
+	nonce := hex.EncodeToString(buf[:])
+
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, nonce)
+	if keyID != "" {
+		req.Header.Set(KeyIDHeader, keyID)
+	}
+	req.Header.Set(SignatureHeader, signatureV2Prefix+signatureV2(key, req, timestamp, nonce, keyID, payload))
+	return nil
+}
+
+// signedHeaders are bound into v2 signatures, in this order.
+var signedHeaders = []string{ExecutionIDHeader, "Last-Event-ID", IdempotencyKeyHeader, "Content-Encoding"}
+
+func signatureV2(key []byte, req *http.Request, timestamp, nonce, keyID string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	canonical := []string{"v2", req.Method, requestTarget(req), timestamp, nonce, keyID}
+	for _, name := range signedHeaders {
+		canonical = append(canonical, req.Header.Get(name))
+	}
+	canonical = append(canonical, hex.EncodeToString(bodyHash[:]))
+	mac := hmac.New(sha256.New, key)
+	_, _ = io.WriteString(mac, strings.Join(canonical, "\n"))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func requestTarget(req *http.Request) string {
+	target := req.URL.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if req.URL.RawQuery != "" {
+		target += "?" + req.URL.RawQuery
+	}
+	return target
+}
+
+// VerifierOptions configures Verifier.
+type VerifierOptions struct {
+	// Keys maps key IDs to signing keys. Keep the previous key listed while
+	// clients rotate to a new one. The empty key ID matches clients that set
+	// no SigningKeyID.
+	Keys map[string][]byte
+
+	// MaxClockSkew is the accepted difference between the request timestamp
+	// and the server clock. Nonces are remembered for twice this long.
+	// Default: 5m
+	MaxClockSkew time.Duration
+
+	// MaxBodyBytes caps the request body read for verification.
+	// Default: 32MiB
+	MaxBodyBytes int64
+}
+
+// Verifier returns middleware that rejects requests without a valid v2
+// signature with 401 before they reach next. It checks the signature, the
+// clock skew, and that each nonce is used only once. Nonces are kept in
+// memory, so replay protection is per process.
+func Verifier(next http.Handler, opts VerifierOptions) http.Handler {
+	return &verifier{next: next, v: NewRequestVerifier(opts)}
+}
+
+type verifier struct {
+	next http.Handler
+	v    *RequestVerifier
+}
+
+func (h *verifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.v.Verify(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// RequestVerifier checks v2 request signatures. It is the engine behind
+// Verifier for servers that are not built on http.Handler chains.
+// It is safe for concurrent use.
+type RequestVerifier struct {
+	keys    map[string][]byte
+	skew    time.Duration
+	maxBody int64
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewRequestVerifier creates a RequestVerifier.
+func NewRequestVerifier(opts VerifierOptions) *RequestVerifier {
+	skew := opts.MaxClockSkew
+	if skew <= 0 {
+		skew = 5 * time.Minute
+	}
+	maxBody := opts.MaxBodyBytes
+	if maxBody <= 0 {
+		maxBody = 32 << 20
+	}
+	keys := make(map[string][]byte, len(opts.Keys))
+	for id, key := range opts.Keys {
+		keys[id] = bytes.Clone(key)
+	}
+	return &RequestVerifier{keys: keys, skew: skew, maxBody: maxBody, nonces: map[string]time.Time{}}
+}
+
+// Verify checks the signature of r. It reads and restores r.Body. Errors
+// wrap ErrSignatureInvalid unless the body could not be read.
+func (v *RequestVerifier) Verify(r *http.Request) error {
+	signature, ok := strings.CutPrefix(r.Header.Get(SignatureHeader), signatureV2Prefix)
+	if !ok {
+		return fmt.Errorf("%w: missing v2 signature", ErrSignatureInvalid)
+	}
+	keyID := r.Header.Get(KeyIDHeader)
+	key, ok := v.keys[keyID]
+	if !ok {
+		return fmt.Errorf("%w: unknown key id %q", ErrSignatureInvalid, keyID)
+	}
+	timestamp := r.Header.Get(TimestampHeader)
+	nonce := r.Header.Get(NonceHeader)
+	if nonce == "" {
+		return fmt.Errorf("%w: missing nonce", ErrSignatureInvalid)
+	}
+	signedAt, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return fmt.Errorf("%w: bad timestamp", ErrSignatureInvalid)
+	}
+	now := time.Now()
+	if d := now.Sub(signedAt); d > v.skew || d < -v.skew {
+		return fmt.Errorf("%w: timestamp outside allowed clock skew", ErrSignatureInvalid)
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, v.maxBody+1))
+		_ = r.Body.Close()
+		if err != nil {
+			return fmt.Errorf("read request body: %w", err)
+		}
+		if int64(len(body)) > v.maxBody {
+			return fmt.Errorf("%w: body too large to verify", ErrSignatureInvalid)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	want := signatureV2(key, r, timestamp, nonce, keyID, body)
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return fmt.Errorf("%w: signature mismatch", ErrSignatureInvalid)
+	}
+
+	// Record the nonce only once the signature is known to be good, so
+	// forged requests cannot burn nonces.
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sweepNonces(now)
+	nonceKey := keyID + "\x00" + nonce
+	if _, seen := v.nonces[nonceKey]; seen {
+		return fmt.Errorf("%w: nonce reused", ErrSignatureInvalid)
+	}
+	v.nonces[nonceKey] = now.Add(2 * v.skew)
+	return nil
+}
+
+// sweepNonces drops nonces older than any acceptable timestamp, at most
+// once a minute. Callers hold v.mu.
+func (v *RequestVerifier) sweepNonces(now time.Time) {
+	if now.Sub(v.lastSweep) < time.Minute {
+		return
+	}
+	v.lastSweep = now
+	for nonce, expires := range v.nonces {
+		if now.After(expires) {
+			delete(v.nonces, nonce)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+func TestVerifierAcceptsSignedClient(t *testing.T) {
+	var captured *http.Request
+	var capturedBody string
+	handler := Verifier(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		captured, capturedBody = r.Clone(context.Background()), string(body)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(remote.RemoteResponse{Result: &remote.ExecuteResultPayload{Stdout: "ok"}})
+	}), VerifierOptions{Keys: map[string][]byte{"k2": []byte("signing-secret")}})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	client, err := NewClient(Config{
+		Endpoint:     srv.URL + "/v1/execute",
+		AuthToken:    "token",
+		SigningKey:   []byte("signing-secret"),
+		SigningKeyID: "k2",
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	resp, err := client.Execute(context.Background(), remote.RemoteRequest{Request: remote.ExecutePayload{Code: "x"}})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Stdout != "ok" {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+	if !strings.HasPrefix(captured.Header.Get(SignatureHeader), "v2=") {
+		t.Fatalf("signature = %q", captured.Header.Get(SignatureHeader))
+	}
+
+	replay := func(path string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(capturedBody))
+		for _, h := range []string{SignatureHeader, TimestampHeader, NonceHeader, KeyIDHeader} {
+			req.Header.Set(h, captured.Header.Get(h))
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("replay error: %v", err)
+		}
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+	if code := replay("/v1/execute"); code != http.StatusUnauthorized {
+		t.Fatalf("replayed request status = %d, want 401", code)
+	}
+	if code := replay("/v1/admin"); code != http.StatusUnauthorized {
+		t.Fatalf("request to another path status = %d, want 401", code)
+	}
+}
+
+func TestRequestVerifierRejects(t *testing.T) {
+	verifier := NewRequestVerifier(VerifierOptions{Keys: map[string][]byte{"k1": []byte("secret")}})
+	sign := func(keyID string, key []byte, at time.Time, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(body))
+		if err := signRequestV2(req, []byte(body), keyID, key, at); err != nil {
+			t.Fatalf("sign error: %v", err)
+		}
+		return req
+	}
+
+	tampered := sign("k1", []byte("secret"), time.Now(), "a")
+	tampered.Body = io.NopCloser(strings.NewReader("b"))
+	legacy := httptest.NewRequest(http.MethodPost, "/execute", nil)
+	signRequest(legacy, nil, "secret")
+
+	cases := map[string]*http.Request{
+		"unknown key": sign("k9", []byte("secret"), time.Now(), "a"),
+		"wrong key":   sign("k1", []byte("other"), time.Now(), "a"),
+		"stale":       sign("k1", []byte("secret"), time.Now().Add(-10*time.Minute), "a"),
+		"tampered":    tampered,
+		"v1":          legacy,
+	}
+	for name, req := range cases {
+		if err := verifier.Verify(req); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%s: expected ErrSignatureInvalid, got %v", name, err)
+		}
+	}
+
+	valid := sign("k1", []byte("secret"), time.Now(), "a")
+	if err := verifier.Verify(valid); err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	if body, _ := io.ReadAll(valid.Body); string(body) != "a" {
+		t.Fatalf("body not restored: %q", body)
+	}
+}
+
+func TestVerifierRejectsRetargetedExecution(t *testing.T) {
+	handler := Verifier(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), VerifierOptions{Keys: map[string][]byte{"k1": []byte("secret")}})
+
+	for _, name := range signedHeaders {
+		req := httptest.NewRequest(http.MethodDelete, "/execute", nil)
+		req.Header.Set(name, "exec-1")
+		if err := signRequestV2(req, nil, "k1", []byte("secret"), time.Now()); err != nil {
+			t.Fatalf("sign error: %v", err)
+		}
+		req.Header.Set(name, "exec-2")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s rewritten after signing: status = %d, want 401", name, rec.Code)
+		}
+	}
+}
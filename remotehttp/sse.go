@@ -2,63 +2,174 @@ package remotehttp
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"io"
+	"math"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// ErrEventTooLarge is returned when a stream line or event exceeds the
+// configured maximum event size.
+var ErrEventTooLarge = errors.New("stream event too large")
+
+// defaultMaxEventSize is the default cap on a single SSE event.
+const defaultMaxEventSize = 16 << 20
+
 type sseEvent struct {
 	Name string
 	Data string
+
+	// ID is the stream's last event ID after this event was dispatched.
+	ID string
 }
 
+// sseDecoder parses a text/event-stream body as specified by the WHATWG HTML
+// standard (section 9.2, "Server-sent events"):
+//   - lines end in CRLF, LF or CR;
+//   - a leading UTF-8 BOM is skipped;
+//   - lines starting with ":" are comments;
+//   - a single space after the field colon is removed, nothing else;
+//   - event, data, id and retry fields are honored, others ignored;
+//   - events without data are not dispatched, events without a type are
+//     named "message", and an incomplete event at EOF is discarded.
 type sseDecoder struct {
-	scanner *bufio.Scanner
+	r       *bufio.Reader
+	maxSize int
+
+	line    []byte
+	started bool
+	skipLF  bool
+
+	eventType   string
+	data        strings.Builder
+	idBuffer    string
+	lastEventID string
+	retry       time.Duration
 }
 
-func newSSEDecoder(r io.Reader) *sseDecoder {
-	scanner := bufio.NewScanner(r)
-	// Allow larger payloads for results/tool calls.
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
-	return &sseDecoder{scanner: scanner}
+func newSSEDecoder(r io.Reader, maxSize int) *sseDecoder {
+	if maxSize <= 0 {
+		maxSize = defaultMaxEventSize
+	}
+	return &sseDecoder{r: bufio.NewReaderSize(r, 64*1024), maxSize: maxSize}
 }
 
 func (d *sseDecoder) next() (sseEvent, error) {
-	var (
-		name    string
-		builder strings.Builder
-	)
-
 	for {
-		if !d.scanner.Scan() {
-			if err := d.scanner.Err(); err != nil {
-				return sseEvent{}, err
+		line, err := d.readLine()
+		if err != nil {
+			return sseEvent{}, err
+		}
+		if len(line) == 0 {
+			d.lastEventID = d.idBuffer
+			if d.data.Len() == 0 {
+				d.eventType = ""
+				continue
 			}
-			if builder.Len() > 0 || name != "" {
-				return sseEvent{Name: name, Data: builder.String()}, nil
+			event := sseEvent{
+				Name: d.eventType,
+				Data: strings.TrimSuffix(d.data.String(), "\n"),
+				ID:   d.lastEventID,
 			}
-			return sseEvent{}, io.EOF
+			if event.Name == "" {
+				event.Name = "message"
+			}
+			d.eventType = ""
+			d.data.Reset()
+			return event, nil
+		}
+		if err := d.processField(line); err != nil {
+			return sseEvent{}, err
 		}
+	}
+}
 
-		line := d.scanner.Text()
-		if line == "" {
-			if builder.Len() == 0 && name == "" {
-				continue
+func (d *sseDecoder) processField(line []byte) error {
+	if line[0] == ':' {
+		return nil
+	}
+	name, value, found := bytes.Cut(line, []byte(":"))
+	if found && len(value) > 0 && value[0] == ' ' {
+		value = value[1:]
+	}
+	switch string(name) {
+	case "event":
+		d.eventType = toValidUTF8(value)
+	case "data":
+		if d.data.Len()+len(value)+1 > d.maxSize {
+			return ErrEventTooLarge
+		}
+		d.data.WriteString(toValidUTF8(value))
+		d.data.WriteByte('\n')
+	case "id":
+		if bytes.IndexByte(value, 0) < 0 {
+			d.idBuffer = toValidUTF8(value)
+		}
+	case "retry":
+		if len(value) > 0 && isASCIIDigits(value) {
+			if ms, err := strconv.ParseInt(string(value), 10, 64); err == nil && ms <= int64(math.MaxInt64/time.Millisecond) {
+				d.retry = time.Duration(ms) * time.Millisecond
 			}
-			return sseEvent{Name: name, Data: builder.String()}, nil
 		}
+	}
+	return nil
+}
 
-		if strings.HasPrefix(line, "event:") {
-			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
-			continue
+// readLine returns the next line without its terminator. The returned slice
+// is only valid until the next call. A trailing line without a terminator is
+// incomplete and is dropped in favor of the read error.
+func (d *sseDecoder) readLine() ([]byte, error) {
+	d.line = d.line[:0]
+	for {
+		if _, err := d.r.Peek(1); err != nil {
+			return nil, err
+		}
+		buffered, _ := d.r.Peek(d.r.Buffered())
+		if d.skipLF {
+			// The previous line ended in CR; a following LF belongs to it.
+			// This is resolved lazily so a CR-terminated event is dispatched
+			// without waiting for the next byte.
+			d.skipLF = false
+			if buffered[0] == '\n' {
+				_, _ = d.r.Discard(1)
+				continue
+			}
 		}
-		if strings.HasPrefix(line, "data:") {
-			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
-			if builder.Len() > 0 {
-				builder.WriteString("\n")
+		i := bytes.IndexAny(buffered, "\r\n")
+		if i < 0 {
+			if len(d.line)+len(buffered) > d.maxSize {
+				return nil, ErrEventTooLarge
 			}
-			builder.WriteString(data)
+			d.line = append(d.line, buffered...)
+			_, _ = d.r.Discard(len(buffered))
 			continue
 		}
+		if len(d.line)+i > d.maxSize {
+			return nil, ErrEventTooLarge
+		}
+		d.line = append(d.line, buffered[:i]...)
+		d.skipLF = buffered[i] == '\r'
+		_, _ = d.r.Discard(i + 1)
+		if !d.started {
+			d.started = true
+			d.line = bytes.TrimPrefix(d.line, []byte("\xEF\xBB\xBF"))
+		}
+		return d.line, nil
+	}
+}
+
+func isASCIIDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
+	return true
+}
+
+func toValidUTF8(b []byte) string {
+	return string(bytes.ToValidUTF8(b, []byte("\uFFFD")))
 }
@@ -0,0 +1,171 @@
+package remotehttp
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
+)
+
+func decodeAll(t testing.TB, r io.Reader, maxSize int) ([]sseEvent, error) {
+	t.Helper()
+	decoder := newSSEDecoder(r, maxSize)
+	var events []sseEvent
+	for {
+		event, err := decoder.next()
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
+
+func TestSSEDecoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []sseEvent
+	}{
+		{
+			name:  "keeps indentation after single space",
+			input: "event: stdout\ndata:   indented  \n\n",
+			want:  []sseEvent{{Name: "stdout", Data: "  indented  "}},
+		},
+		{
+			name:  "no space after colon",
+			input: "data:x\n\n",
+			want:  []sseEvent{{Name: "message", Data: "x"}},
+		},
+		{
+			name:  "multi-line data",
+			input: "data: a\ndata\ndata: b\n\n",
+			want:  []sseEvent{{Name: "message", Data: "a\n\nb"}},
+		},
+		{
+			name:  "CRLF and CR line endings",
+			input: "event: stdout\r\ndata: a\r\rdata: b\r\n\r\n",
+			want:  []sseEvent{{Name: "stdout", Data: "a"}, {Name: "message", Data: "b"}},
+		},
+		{
+			name:  "comments and unknown fields",
+			input: ": keepalive\nfoo: bar\ndata: x\n\n",
+			want:  []sseEvent{{Name: "message", Data: "x"}},
+		},
+		{
+			name:  "id persists across events",
+			input: "id: 1\ndata: a\n\ndata: b\n\nid\ndata: c\n\n",
+			want: []sseEvent{
+				{Name: "message", Data: "a", ID: "1"},
+				{Name: "message", Data: "b", ID: "1"},
+				{Name: "message", Data: "c", ID: ""},
+			},
+		},
+		{
+			name:  "id with NUL ignored",
+			input: "id: 1\ndata: a\n\nid: 2\x00\ndata: b\n\n",
+			want:  []sseEvent{{Name: "message", Data: "a", ID: "1"}, {Name: "message", Data: "b", ID: "1"}},
+		},
+		{
+			name:  "event without data is not dispatched",
+			input: "event: stdout\n\ndata: x\n\n",
+			want:  []sseEvent{{Name: "message", Data: "x"}},
+		},
+		{
+			name:  "empty data field dispatches",
+			input: "event: stdout\ndata\n\n",
+			want:  []sseEvent{{Name: "stdout", Data: ""}},
+		},
+		{
+			name:  "leading BOM",
+			input: "\xEF\xBB\xBFdata: x\n\n",
+			want:  []sseEvent{{Name: "message", Data: "x"}},
+		},
+		{
+			name:  "incomplete event at EOF is discarded",
+			input: "data: a\n\ndata: b\n",
+			want:  []sseEvent{{Name: "message", Data: "a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeAll(t, strings.NewReader(tt.input), 0)
+			if err != nil {
+				t.Fatalf("decode error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("events = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSEDecoderRetry(t *testing.T) {
+	decoder := newSSEDecoder(strings.NewReader("retry: 1500\nretry: 2s\ndata: x\n\n"), 0)
+	if _, err := decoder.next(); err != nil {
+		t.Fatalf("next error: %v", err)
+	}
+	if decoder.retry != 1500*time.Millisecond {
+		t.Fatalf("retry = %v, want 1.5s", decoder.retry)
+	}
+}
+
+func TestSSEDecoderLargeEvent(t *testing.T) {
+	payload := strings.Repeat("x", 2<<20)
+	events, err := decodeAll(t, strings.NewReader("data: "+payload+"\n\n"), 0)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(events) != 1 || events[0].Data != payload {
+		t.Fatalf("large event not decoded intact")
+	}
+}
+
+func TestSSEDecoderMaxEventSize(t *testing.T) {
+	for _, input := range []string{
+		"data: " + strings.Repeat("x", 100) + "\n\n",
+		strings.Repeat("data: xxxxxxxx\n", 20) + "\n",
+	} {
+		_, err := decodeAll(t, strings.NewReader(input), 64)
+		if !errors.Is(err, ErrEventTooLarge) {
+			t.Fatalf("expected ErrEventTooLarge, got %v", err)
+		}
+	}
+}
+
+func FuzzSSEDecoder(f *testing.F) {
+	for _, seed := range []string{
+		"event: stdout\ndata: hello\n\n",
+		"data: a\r\ndata: b\r\n\r\n",
+		"id: 7\rretry: 10\rdata\r\r",
+		": comment\n\xEF\xBB\xBFdata:x\n\n",
+		"data: \xff\xfe\n\n",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		const maxSize = 4096
+		events, err := decodeAll(t, strings.NewReader(input), maxSize)
+		if err != nil && !errors.Is(err, ErrEventTooLarge) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, event := range events {
+			if len(event.Data) > 3*maxSize || !utf8.ValidString(event.Data) || event.Name == "" {
+				t.Fatalf("invalid event %#v", event)
+			}
+		}
+
+		// Chunk boundaries must not change the result, including a CRLF
+		// split across reads.
+		split, splitErr := decodeAll(t, iotest.OneByteReader(strings.NewReader(input)), maxSize)
+		if !reflect.DeepEqual(events, split) || (err == nil) != (splitErr == nil) {
+			t.Fatalf("one-byte reads differ: %#v vs %#v", events, split)
+		}
+	})
+}
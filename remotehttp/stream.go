@@ -0,0 +1,210 @@
+package remotehttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// SSE event names used by remote runtimes.
+const (
+	EventStdout   = "stdout"
+	EventStderr   = "stderr"
+	EventProgress = "progress"
+	EventResult   = "result"
+	EventError    = "error"
+
+	// EventToolCall reports a tool invocation starting inside remote code,
+	// and EventToolCallResult its completion. Both carry a ToolCallEvent.
+	EventToolCall       = "toolcall"
+	EventToolCallResult = "toolcall_result"
+)
+
+// StreamEvent is a live event from a streaming execution.
+type StreamEvent struct {
+	// Name is the SSE event name (see the Event constants). Events the client
+	// does not interpret are passed through with their raw name and data.
+	Name string
+
+	// Data is the raw event payload: output text for stdout/stderr, the
+	// server's JSON for progress and unknown events, or the error message.
+	Data string
+
+	// ID is the stream's last event ID, if the server assigns IDs.
+	ID string
+
+	// ToolRequest is set on toolrequest events. The client answers them
+	// itself using Config.ToolHandlers.
+	ToolRequest *ToolRequest
+
+	// ToolCall is set on toolcall and toolcall_result events.
+	ToolCall *ToolCallEvent
+
+	// Result is set on the final result event.
+	Result *remote.ExecuteResultPayload
+}
+
+// ToolCallEvent is the JSON payload of toolcall and toolcall_result events.
+// A toolcall_result carries the duration and error of the call; its other
+// fields may be omitted when CallID matches an earlier toolcall.
+type ToolCallEvent struct {
+	// CallID correlates a toolcall event with its toolcall_result.
+	CallID string `json:"call_id,omitempty"`
+
+	remote.ToolCallPayload
+}
+
+// StreamHandler receives streaming events. See Client.ExecuteStream.
+type StreamHandler func(StreamEvent)
+
+// streamState accumulates one streamed execution across reconnects.
+type streamState struct {
+	result      remote.ExecuteResultPayload
+	toolCalls   map[string]int // CallID -> index in result.ToolCalls
+	tools       *toolDispatcher
+	endpoint    *url.URL
+	executionID string
+	lastEventID string
+	retry       time.Duration
+
+	// events counts dispatched events; done is set once a terminal result or
+	// error event was received.
+	events int
+	done   bool
+}
+
+// readStream consumes one SSE response body into state. A clean EOF returns
+// nil even if the stream has not reached a terminal event.
+func (c *Client) readStream(body io.Reader, call *call, state *streamState) error {
+	decoder := newSSEDecoder(body, c.maxEventSize)
+	decoder.idBuffer = state.lastEventID
+	decoder.lastEventID = state.lastEventID
+	defer func() {
+		state.lastEventID = decoder.lastEventID
+		if decoder.retry > 0 {
+			state.retry = decoder.retry
+		}
+	}()
+
+	result := &state.result
+	for {
+		event, err := decoder.next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if errors.Is(err, ErrEventTooLarge) {
+				return fmt.Errorf("%w: stream decode: %w", remote.ErrRemoteExecutionFailed, err)
+			}
+			return fmt.Errorf("%w: stream read: %w", remote.ErrRemoteExecutionFailed, err)
+		}
+		state.events++
+		switch event.Name {
+		case EventStdout:
+			result.Stdout += event.Data
+			call.emit(StreamEvent{Name: EventStdout, Data: event.Data, ID: event.ID})
+		case EventStderr:
+			result.Stderr += event.Data
+			call.emit(StreamEvent{Name: EventStderr, Data: event.Data, ID: event.ID})
+		case EventToolCall, EventToolCallResult:
+			var toolCall ToolCallEvent
+			if err := json.Unmarshal([]byte(event.Data), &toolCall); err == nil {
+				state.recordToolCall(event.Name, toolCall)
+				call.emit(StreamEvent{Name: event.Name, Data: event.Data, ID: event.ID, ToolCall: &toolCall})
+			}
+		case EventExecution:
+			state.recordExecution(event.Data)
+			call.emit(StreamEvent{Name: event.Name, Data: event.Data, ID: event.ID})
+		case EventToolRequest:
+			var toolReq ToolRequest
+			if err := json.Unmarshal([]byte(event.Data), &toolReq); err == nil {
+				call.emit(StreamEvent{Name: event.Name, Data: event.Data, ID: event.ID, ToolRequest: &toolReq})
+				state.tools.dispatch(state.executionID, toolReq)
+			}
+		case EventToolCancel:
+			var toolReq ToolRequest
+			if err := json.Unmarshal([]byte(event.Data), &toolReq); err == nil {
+				state.tools.cancelRequest(toolReq.RequestID)
+			}
+			call.emit(StreamEvent{Name: event.Name, Data: event.Data, ID: event.ID})
+		case EventResult:
+			var payload remote.ExecuteResultPayload
+			if err := json.Unmarshal([]byte(event.Data), &payload); err == nil {
+				if payload.Stdout == "" {
+					payload.Stdout = result.Stdout
+				}
+				if payload.Stderr == "" {
+					payload.Stderr = result.Stderr
+				}
+				if len(payload.ToolCalls) == 0 {
+					payload.ToolCalls = result.ToolCalls
+				}
+				*result = payload
+				state.done = true
+				call.emit(StreamEvent{Name: EventResult, Data: event.Data, ID: event.ID, Result: &payload})
+			}
+		case EventError:
+			state.done = true
+			call.emit(StreamEvent{Name: EventError, Data: event.Data, ID: event.ID})
+			return fmt.Errorf("%w: %s", remote.ErrRemoteExecutionFailed, event.Data)
+		default:
+			call.emit(StreamEvent{Name: event.Name, Data: event.Data, ID: event.ID})
+		}
+	}
+}
+
+// recordToolCall adds a toolcall to the result, or completes the entry of
+// an earlier toolcall with the same CallID.
+func (s *streamState) recordToolCall(name string, event ToolCallEvent) {
+	if name == EventToolCallResult && event.CallID != "" {
+		if i, ok := s.toolCalls[event.CallID]; ok {
+			entry := &s.result.ToolCalls[i]
+			if event.ToolID != "" {
+				entry.ToolID = event.ToolID
+			}
+			if event.BackendKind != "" {
+				entry.BackendKind = event.BackendKind
+			}
+			entry.DurationMs = event.DurationMs
+			entry.ErrorOp = event.ErrorOp
+			return
+		}
+	}
+	s.result.ToolCalls = append(s.result.ToolCalls, event.ToolCallPayload)
+	if name == EventToolCall && event.CallID != "" {
+		if s.toolCalls == nil {
+			s.toolCalls = map[string]int{}
+		}
+		s.toolCalls[event.CallID] = len(s.result.ToolCalls) - 1
+	}
+}
+
+// emitResponse replays a non-streamed response to the stream handler so
+// ExecuteStream callers see the same event sequence either way.
+func emitResponse(call *call, response remote.RemoteResponse) {
+	if call.handler == nil {
+		return
+	}
+	if response.Error != nil {
+		call.emit(StreamEvent{Name: EventError, Data: response.Error.Message})
+		return
+	}
+	if response.Result == nil {
+		return
+	}
+	if response.Result.Stdout != "" {
+		call.emit(StreamEvent{Name: EventStdout, Data: response.Result.Stdout})
+	}
+	if response.Result.Stderr != "" {
+		call.emit(StreamEvent{Name: EventStderr, Data: response.Result.Stderr})
+	}
+	for _, toolCall := range response.Result.ToolCalls {
+		call.emit(StreamEvent{Name: EventToolCallResult, ToolCall: &ToolCallEvent{ToolCallPayload: toolCall}})
+	}
+	call.emit(StreamEvent{Name: EventResult, Result: response.Result})
+}
@@ -0,0 +1,162 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+func TestClientExecuteStreamDeliversEventsLive(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Accept"); got != "text/event-stream" {
+			t.Errorf("Accept = %q", got)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: stdout\ndata: hello\n\n"))
+		_, _ = w.Write([]byte("event: progress\ndata: {\"pct\":50}\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("event: stderr\ndata: warn\n\n"))
+		data, _ := json.Marshal(remote.ExecuteResultPayload{Value: "ok"})
+		_, _ = w.Write([]byte("event: result\ndata: " + string(data) + "\n\n"))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	var events []StreamEvent
+	resp, err := client.ExecuteStream(context.Background(), remote.RemoteRequest{
+		Request: remote.ExecutePayload{Code: "return"},
+	}, func(event StreamEvent) {
+		events = append(events, event)
+		if event.Name == EventProgress {
+			// The server is blocked until the first events were observed.
+			close(release)
+		}
+	})
+	if err != nil {
+		t.Fatalf("ExecuteStream error: %v", err)
+	}
+
+	names := make([]string, 0, len(events))
+	for _, event := range events {
+		names = append(names, event.Name)
+	}
+	want := []string{EventStdout, EventProgress, EventStderr, EventResult}
+	if len(names) != len(want) {
+		t.Fatalf("events = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("events = %v, want %v", names, want)
+		}
+	}
+	if events[1].Data != `{"pct":50}` {
+		t.Fatalf("progress data = %q", events[1].Data)
+	}
+	if events[3].Result == nil || events[3].Result.Value != "ok" {
+		t.Fatalf("result event = %#v", events[3])
+	}
+	if resp.Result == nil || resp.Result.Stdout != "hello" || resp.Result.Stderr != "warn" {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+}
+
+func TestClientExecuteStreamJSONFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(remote.RemoteResponse{Result: &remote.ExecuteResultPayload{Stdout: "out", Value: 1.0}})
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	var names []string
+	_, err = client.ExecuteStream(context.Background(), remote.RemoteRequest{}, func(event StreamEvent) {
+		names = append(names, event.Name)
+	})
+	if err != nil {
+		t.Fatalf("ExecuteStream error: %v", err)
+	}
+	if len(names) != 2 || names[0] != EventStdout || names[1] != EventResult {
+		t.Fatalf("events = %v", names)
+	}
+}
+
+func TestClientExecuteStreamDoesNotRetryAfterDelivery(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: stdout\ndata: partial\n\n"))
+		_, _ = w.Write([]byte("event: error\ndata: boom\n\n"))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{Endpoint: srv.URL, MaxRetries: 2})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	var last StreamEvent
+	_, err = client.ExecuteStream(context.Background(), remote.RemoteRequest{}, func(event StreamEvent) {
+		last = event
+	})
+	if !errors.Is(err, remote.ErrRemoteExecutionFailed) {
+		t.Fatalf("expected ErrRemoteExecutionFailed, got %v", err)
+	}
+	if last.Name != EventError || last.Data != "boom" {
+		t.Fatalf("last event = %#v", last)
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+}
+
+func TestClientExecuteStreamToolCalls(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: toolcall\ndata: {\"call_id\":\"c1\",\"tool_id\":\"fs.read\",\"backend_kind\":\"mcp\"}\n\n"))
+		_, _ = w.Write([]byte("event: toolcall\ndata: {\"call_id\":\"c2\",\"tool_id\":\"http.get\",\"backend_kind\":\"local\"}\n\n"))
+		_, _ = w.Write([]byte("event: toolcall_result\ndata: {\"call_id\":\"c2\",\"duration_ms\":7,\"error_op\":\"timeout\"}\n\n"))
+		_, _ = w.Write([]byte("event: toolcall_result\ndata: {\"call_id\":\"c1\",\"duration_ms\":3}\n\n"))
+		_, _ = w.Write([]byte("event: result\ndata: {\"value\":1}\n\n"))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(Config{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	var seen []string
+	resp, err := client.ExecuteStream(context.Background(), remote.RemoteRequest{}, func(event StreamEvent) {
+		if event.ToolCall != nil {
+			seen = append(seen, event.Name+":"+event.ToolCall.CallID)
+		}
+	})
+	if err != nil {
+		t.Fatalf("ExecuteStream error: %v", err)
+	}
+	if got := strings.Join(seen, ","); got != "toolcall:c1,toolcall:c2,toolcall_result:c2,toolcall_result:c1" {
+		t.Fatalf("tool call events = %s", got)
+	}
+	want := []remote.ToolCallPayload{
+		{ToolID: "fs.read", BackendKind: "mcp", DurationMs: 3},
+		{ToolID: "http.get", BackendKind: "local", DurationMs: 7, ErrorOp: "timeout"},
+	}
+	if !reflect.DeepEqual(resp.Result.ToolCalls, want) {
+		t.Fatalf("ToolCalls = %#v, want %#v", resp.Result.ToolCalls, want)
+	}
+}
@@ -0,0 +1,227 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// Tool callback event names. See ToolRequest.
+const (
+	EventToolRequest = "toolrequest"
+	EventToolCancel  = "toolcancel"
+)
+
+// ToolHandler runs a host tool on behalf of remote code. args holds the raw
+// JSON arguments of the request; the returned value is JSON encoded into the
+// tool response. ctx is canceled when the call times out, the server cancels
+// it, or the execution ends.
+type ToolHandler func(ctx context.Context, args json.RawMessage) (any, error)
+
+// ToolRequest is the payload of a toolrequest event.
+//
+// Server contract: during a streaming execution that has an execution ID
+// (see ExecutionIDHeader), the server asks the client to run a host tool by
+// sending a toolrequest event. The client answers with
+//
+//	POST <endpoint>
+//	X-Toolruntime-Execution-Id: <execution id>
+//
+// carrying a ToolResponse with the same request_id, signed like an Execute
+// request. A toolcancel event with {"request_id": ...} abandons a pending
+// request; no response is sent for it. Requests still pending when the
+// stream reaches its result are abandoned too.
+type ToolRequest struct {
+	RequestID string          `json:"request_id"`
+	ToolID    string          `json:"tool_id"`
+	Args      json.RawMessage `json:"args,omitempty"`
+
+	// TimeoutMs shortens the client's ToolTimeout for this request.
+	TimeoutMs int64 `json:"timeout_ms,omitempty"`
+}
+
+// ToolResponse is the body of a tool callback POST. Exactly one of Result
+// and Error is set.
+type ToolResponse struct {
+	RequestID string          `json:"request_id"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// toolDispatcher runs the tool requests of one streamed execution.
+type toolDispatcher struct {
+	client   *Client
+	endpoint *url.URL
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[string]context.CancelFunc
+}
+
+func (c *Client) newToolDispatcher(ctx context.Context, endpoint *url.URL) *toolDispatcher {
+	ctx, cancel := context.WithCancel(ctx)
+	return &toolDispatcher{client: c, endpoint: endpoint, ctx: ctx, cancel: cancel, pending: map[string]context.CancelFunc{}}
+}
+
+// dispatch runs req in the background and posts its response.
+func (d *toolDispatcher) dispatch(executionID string, req ToolRequest) {
+	logger := d.client.logger
+	if executionID == "" || req.RequestID == "" {
+		if logger != nil {
+			logger.Warn("remote tool request cannot be answered", "tool_id", req.ToolID, "request_id", req.RequestID)
+		}
+		return
+	}
+
+	timeout := d.client.toolTimeout
+	if req.TimeoutMs > 0 {
+		timeout = min(timeout, time.Duration(req.TimeoutMs)*time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(d.ctx, timeout)
+
+	d.mu.Lock()
+	if _, ok := d.pending[req.RequestID]; ok {
+		d.mu.Unlock()
+		cancel()
+		return
+	}
+	d.pending[req.RequestID] = cancel
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer func() {
+			d.mu.Lock()
+			delete(d.pending, req.RequestID)
+			d.mu.Unlock()
+			cancel()
+		}()
+
+		response, ok := d.client.runTool(ctx, req)
+		if !ok {
+			return
+		}
+		if err := d.client.postToolResponse(d.ctx, d.endpoint, executionID, response); err != nil && logger != nil {
+			logger.Warn("remote tool response failed", "tool_id", req.ToolID, "request_id", req.RequestID, "error", err)
+		}
+	}()
+}
+
+// cancelRequest abandons a pending request after a toolcancel event.
+func (d *toolDispatcher) cancelRequest(requestID string) {
+	d.mu.Lock()
+	cancel, ok := d.pending[requestID]
+	delete(d.pending, requestID)
+	d.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// close abandons pending requests and waits for their goroutines.
+func (d *toolDispatcher) close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// runTool runs the handler for req. It returns false if the request was
+// canceled and must not be answered. A handler that ignores ctx is left
+// running in the background.
+func (c *Client) runTool(ctx context.Context, req ToolRequest) (ToolResponse, bool) {
+	response := ToolResponse{RequestID: req.RequestID}
+	handler, ok := c.tools[req.ToolID]
+	if !ok {
+		response.Error = fmt.Sprintf("unknown tool %q", req.ToolID)
+		return response, true
+	}
+
+	type outcome struct {
+		value any
+		err   error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		value, err := handler(ctx, req.Args)
+		done <- outcome{value: value, err: err}
+	}()
+
+	var out outcome
+	select {
+	case out = <-done:
+	case <-ctx.Done():
+		out.err = ctx.Err()
+	}
+	if errors.Is(out.err, context.Canceled) && ctx.Err() != nil {
+		return ToolResponse{}, false
+	}
+	if errors.Is(out.err, context.DeadlineExceeded) && ctx.Err() != nil {
+		response.Error = "tool call timed out"
+		return response, true
+	}
+	if out.err != nil {
+		response.Error = out.err.Error()
+		return response, true
+	}
+	data, err := json.Marshal(out.value)
+	if err != nil {
+		response.Error = fmt.Sprintf("encode tool result: %v", err)
+		return response, true
+	}
+	response.Result = data
+	return response, true
+}
+
+func (c *Client) postToolResponse(ctx context.Context, endpoint *url.URL, executionID string, response ToolResponse) error {
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = c.sendToolResponse(ctx, endpoint, executionID, payload)
+		if err == nil || attempt > c.maxRetries {
+			return err
+		}
+		delay, ok := c.retry.Backoff(attempt, err)
+		if !ok {
+			return err
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) sendToolResponse(ctx context.Context, endpoint *url.URL, executionID string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), strings.NewReader(string(payload)))
+	if err != nil {
+		return fmt.Errorf("%w: build request: %v", remote.ErrConnectionFailed, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(ExecutionIDHeader, executionID)
+	resp, err := c.do(req, payload)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(body)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package remotehttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonwraymond/toolexec/runtime/backend/remote"
+)
+
+// newToolServer streams the given tool request events and then waits for
+// one tool response per request before sending the result. Callbacks are
+// delivered on the returned channel.
+func newToolServer(t *testing.T, events []string, wantResponses int) (*httptest.Server, chan ToolResponse) {
+	t.Helper()
+	responses := make(chan ToolResponse, 16)
+	received := make(chan ToolResponse, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get(ExecutionIDHeader); id != "" {
+			if id != "exec-1" {
+				t.Errorf("callback execution id = %q", id)
+			}
+			var response ToolResponse
+			if err := json.NewDecoder(r.Body).Decode(&response); err != nil {
+				t.Errorf("decode tool response: %v", err)
+			}
+			responses <- response
+			received <- response
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set(ExecutionIDHeader, "exec-1")
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			_, _ = w.Write([]byte(event))
+		}
+		w.(http.Flusher).Flush()
+
+		var results []string
+		for range wantResponses {
+			select {
+			case response := <-received:
+				results = append(results, string(response.Result)+response.Error)
+			case <-time.After(5 * time.Second):
+				t.Error("timed out waiting for tool response")
+				return
+			}
+		}
+		data, _ := json.Marshal(remote.ExecuteResultPayload{Value: fmt.Sprint(results)})
+		_, _ = w.Write([]byte("event: result\ndata: " + string(data) + "\n\n"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, responses
+}
+
+func TestClientExecuteAnswersToolRequests(t *testing.T) {
+	srv, responses := newToolServer(t, []string{
+		"event: toolrequest\ndata: {\"request_id\":\"r1\",\"tool_id\":\"add\",\"args\":{\"a\":1,\"b\":2}}\n\n",
+	}, 1)
+	client, err := NewClient(Config{
+		Endpoint: srv.URL,
+		ToolHandlers: map[string]ToolHandler{
+			"add": func(_ context.Context, args json.RawMessage) (any, error) {
+				var in struct{ A, B int }
+				if err := json.Unmarshal(args, &in); err != nil {
+					return nil, err
+				}
+				return in.A + in.B, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+
+	// Tool handlers imply streaming even through Execute.
+	resp, err := client.Execute(context.Background(), remote.RemoteRequest{})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if resp.Result == nil || resp.Result.Value != "[3]" {
+		t.Fatalf("unexpected result: %#v", resp.Result)
+	}
+	response := <-responses
+	if response.RequestID != "r1" || string(response.Result) != "3" {
+		t.Fatalf("tool response = %#v", response)
+	}
+}
+
+func TestClientExecuteToolRequestErrors(t *testing.T) {
+	srv, responses := newToolServer(t, []string{
+		"event: toolrequest\ndata: {\"request_id\":\"r1\",\"tool_id\":\"missing\"}\n\n",
+		"event: toolrequest\ndata: {\"request_id\":\"r2\",\"tool_id\":\"slow\",\"timeout_ms\":20}\n\n",
+	}, 2)
+	client, err := NewClient(Config{
+		Endpoint: srv.URL,
+		ToolHandlers: map[string]ToolHandler{
+			"slow": func(ctx context.Context, _ json.RawMessage) (any, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	if _, err := client.Execute(context.Background(), remote.RemoteRequest{}); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+
+	got := map[string]string{}
+	for range 2 {
+		response := <-responses
+		got[response.RequestID] = response.Error
+	}
+	if got["r1"] != `unknown tool "missing"` || got["r2"] != "tool call timed out" {
+		t.Fatalf("tool errors = %#v", got)
+	}
+}
+
+func TestClientExecuteToolCancel(t *testing.T) {
+	canceled := make(chan struct{})
+	srv, responses := newToolServer(t, []string{
+		"event: toolrequest\ndata: {\"request_id\":\"r1\",\"tool_id\":\"wait\"}\n\n",
+		"event: toolcancel\ndata: {\"request_id\":\"r1\"}\n\n",
+	}, 0)
+	client, err := NewClient(Config{
+		Endpoint: srv.URL,
+		ToolHandlers: map[string]ToolHandler{
+			"wait": func(ctx context.Context, _ json.RawMessage) (any, error) {
+				<-ctx.Done()
+				close(canceled)
+				return nil, ctx.Err()
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	if _, err := client.Execute(context.Background(), remote.RemoteRequest{}); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tool handler was not canceled")
+	}
+	select {
+	case response := <-responses:
+		t.Fatalf("unexpected response for canceled request: %#v", response)
+	case <-time.After(50 * time.Millisecond):
+	}
+}